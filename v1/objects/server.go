@@ -22,6 +22,12 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 			log.Print(err)
 		}
 	}
+
+	if method == http.MethodDelete {
+		if err := del(w, r); err != nil {
+			log.Print(err)
+		}
+	}
 }
 
 func put(w http.ResponseWriter, r *http.Request) error {
@@ -73,4 +79,21 @@ func get(w http.ResponseWriter, r *http.Request) error {
 
 	log.Printf("文件读取成功: %s", f.Name())
 	return err
-}
\ No newline at end of file
+}
+
+func del(w http.ResponseWriter, r *http.Request) error {
+	fn := os.Getenv("STORAGE_ROOT") + "/objects/" + strings.Split(r.URL.EscapedPath(), "/")[2]
+	err := os.Remove(fn)
+	if os.IsNotExist(err) {
+		w.WriteHeader(http.StatusNotFound)
+		return err
+	}
+	if err != nil {
+		log.Println("文件删除失败")
+		w.WriteHeader(http.StatusInternalServerError)
+		return err
+	}
+
+	log.Printf("文件删除成功: %s", fn)
+	return nil
+}
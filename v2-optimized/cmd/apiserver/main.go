@@ -111,10 +111,25 @@ func (s *APIServer) handleObjects(w http.ResponseWriter, r *http.Request) {
 	s.loadBalancer.UpdateStats(selectedServer.ID, responseTime, success)
 }
 
+// hopHeaders 只对单个连接有效, 代理时不转发的请求头与响应头
+var hopHeaders = map[string]bool{
+	"Connection":          true,
+	"Keep-Alive":          true,
+	"Proxy-Authenticate":  true,
+	"Proxy-Authorization": true,
+	"Te":                  true,
+	"Trailer":             true,
+	"Transfer-Encoding":   true,
+	"Upgrade":             true,
+}
+
 // proxyRequest 代理请求到数据服务器
 func (s *APIServer) proxyRequest(w http.ResponseWriter, r *http.Request, server *discovery.ServiceInfo) bool {
-	// 构建目标URL
+	// 构建目标URL, 保留查询参数, 如修改元数据的 ?metadata 与读取历史版本的 ?version=
 	targetURL := fmt.Sprintf("http://%s:%d%s", server.Address, server.Port, r.URL.Path)
+	if r.URL.RawQuery != "" {
+		targetURL += "?" + r.URL.RawQuery
+	}
 	
 	// 创建新请求
 	req, err := http.NewRequest(r.Method, targetURL, r.Body)
@@ -122,9 +137,13 @@ func (s *APIServer) proxyRequest(w http.ResponseWriter, r *http.Request, server
 		http.Error(w, "Failed to create request", http.StatusInternalServerError)
 		return false
 	}
+	req.ContentLength = r.ContentLength
 	
-	// 复制请求头
+	// 复制请求头, X-Meta-* 与 Content-Type 等由后端随对象版本保存
 	for key, values := range r.Header {
+		if hopHeaders[key] {
+			continue
+		}
 		for _, value := range values {
 			req.Header.Add(key, value)
 		}
@@ -141,6 +160,9 @@ func (s *APIServer) proxyRequest(w http.ResponseWriter, r *http.Request, server
 	
 	// 复制响应头
 	for key, values := range resp.Header {
+		if hopHeaders[key] {
+			continue
+		}
 		for _, value := range values {
 			w.Header().Add(key, value)
 		}
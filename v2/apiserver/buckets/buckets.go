@@ -0,0 +1,238 @@
+package buckets
+
+import (
+	"dot/v2/apiserver/metadata"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bucket 存储桶及其策略
+type Bucket struct {
+	Name    string    `json:"name"`
+	Created time.Time `json:"created"`
+	// Scheme 存储桶中新写入对象的冗余策略, 如 single, rs-4-2, replica-3-2, 为空时使用 STORAGE_SCHEME
+	Scheme string `json:"scheme,omitempty"`
+	// MaxSize 单个对象的最大字节数, 0 表示不限制
+	MaxSize int64 `json:"maxSize,omitempty"`
+	// Versioning 是否保留对象的历史版本, 关闭时新写入的数据覆盖最新版本
+	Versioning bool `json:"versioning"`
+	// Compression 数据服务保存新写入对象时使用的压缩算法, gzip 或 zstd, 为空表示不压缩;
+	// 压缩保存的对象不能随机读取, Range 请求需要从头解压并丢弃之前的数据, 读取大对象末尾的开销与读取整个对象相同,
+	// 需要频繁 Range 读取(如音视频, 断点下载)的存储桶不宜开启
+	Compression string `json:"compression,omitempty"`
+}
+
+// Legacy 存储桶功能之前写入的对象所在的默认存储桶, 这些对象名不含 '/', 仍以 /objects/<name> 访问;
+// 与当时的行为一致保留历史版本, 冗余策略为 STORAGE_SCHEME
+var Legacy = Bucket{Versioning: true}
+
+var (
+	ErrExists   = errors.New("bucket already exists")
+	ErrNotFound = errors.New("bucket not found")
+	ErrNotEmpty = errors.New("bucket not empty")
+)
+
+var (
+	mutex   sync.RWMutex
+	buckets map[string]Bucket
+	once    sync.Once
+	pattern = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+)
+
+// ValidName 存储桶名由 3 到 63 个小写字母, 数字, '.' 和 '-' 组成, 以字母或数字开头和结尾
+//
+// 以 '.' 开头的存储桶保留给系统内部使用, 如 S3 分段上传的分段
+func ValidName(name string) bool {
+	return pattern.MatchString(name) && !strings.Contains(name, "..")
+}
+
+// Reserved 是否为系统内部使用的存储桶: 以 '.' 开头, 不能通过 /buckets, /objects 与 S3 接口访问
+func Reserved(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
+
+// Of 返回对象 <bucket>/<key> 所在的存储桶, 不含 '/' 的对象名属于 Legacy, 存储桶不存在时返回 false
+func Of(name string) (Bucket, bool) {
+	bucket, _, found := strings.Cut(name, "/")
+	if !found {
+		return Legacy, true
+	}
+	return Get(bucket)
+}
+
+// bucketsFile 存储桶列表保存在元数据目录下的 buckets.json 中
+func bucketsFile() string {
+	return filepath.Join(metadata.Root(), "buckets.json")
+}
+
+func load() {
+	once.Do(func() {
+		buckets = make(map[string]Bucket)
+		b, err := os.ReadFile(bucketsFile())
+		if errors.Is(err, os.ErrNotExist) {
+			return
+		}
+		if err == nil {
+			err = json.Unmarshal(b, &buckets)
+		}
+		if err != nil {
+			log.Fatalf("Failed to load buckets from %s: %v", bucketsFile(), err)
+		}
+	})
+}
+
+// save 写入存储桶列表, 调用方需持有写锁
+func save() error {
+	b, _ := json.Marshal(buckets)
+	err := os.MkdirAll(metadata.Root(), 0755)
+	if err != nil {
+		return err
+	}
+	tmp := bucketsFile() + ".tmp"
+	err = os.WriteFile(tmp, b, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, bucketsFile())
+}
+
+// Get 查找存储桶, 不存在时返回 false
+func Get(name string) (Bucket, bool) {
+	load()
+	mutex.RLock()
+	defer mutex.RUnlock()
+	b, ok := buckets[name]
+	return b, ok
+}
+
+// List 按名字顺序列出所有存储桶
+func List() []Bucket {
+	load()
+	mutex.RLock()
+	defer mutex.RUnlock()
+	result := make([]Bucket, 0, len(buckets))
+	for _, b := range buckets {
+		result = append(result, b)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// Create 创建存储桶, 不校验存储桶名, 但不能创建系统内部使用的存储桶, 见 Internal
+func Create(b Bucket) (Bucket, error) {
+	if Reserved(b.Name) {
+		return Bucket{}, fmt.Errorf("bucket %s is reserved", b.Name)
+	}
+	return create(b)
+}
+
+// Internal 返回系统内部使用的存储桶 name, 不存在时以默认策略(不保留历史版本)创建, name 须以 '.' 开头
+func Internal(name string) (Bucket, error) {
+	if !Reserved(name) {
+		return Bucket{}, fmt.Errorf("bucket %s is not reserved", name)
+	}
+	if b, ok := Get(name); ok {
+		return b, nil
+	}
+	b, err := create(Bucket{Name: name})
+	if errors.Is(err, ErrExists) {
+		b, _ = Get(name)
+		return b, nil
+	}
+	return b, err
+}
+
+func create(b Bucket) (Bucket, error) {
+	load()
+	mutex.Lock()
+	defer mutex.Unlock()
+	if _, ok := buckets[b.Name]; ok {
+		return Bucket{}, ErrExists
+	}
+	b.Created = time.Now().UTC()
+	buckets[b.Name] = b
+	err := save()
+	if err != nil {
+		delete(buckets, b.Name)
+		return Bucket{}, err
+	}
+	return b, nil
+}
+
+// Update 修改存储桶的策略, 只影响之后写入的对象
+func Update(b Bucket) (Bucket, error) {
+	load()
+	mutex.Lock()
+	defer mutex.Unlock()
+	old, ok := buckets[b.Name]
+	if !ok {
+		return Bucket{}, ErrNotFound
+	}
+	b.Created = old.Created
+	buckets[b.Name] = b
+	err := save()
+	if err != nil {
+		buckets[b.Name] = old
+		return Bucket{}, err
+	}
+	return b, nil
+}
+
+// Delete 删除存储桶, 存储桶中仍有对象的任何版本(包括删除标记与历史版本)时返回 ErrNotEmpty;
+// 检查与删除期间持有写锁, 正在写入元数据的请求(见 Hold)完成后才检查
+func Delete(name string) error {
+	load()
+	mutex.Lock()
+	defer mutex.Unlock()
+	b, ok := buckets[name]
+	if !ok {
+		return ErrNotFound
+	}
+	empty, err := isEmpty(name)
+	if err != nil {
+		return err
+	}
+	if !empty {
+		return ErrNotEmpty
+	}
+	delete(buckets, name)
+	err = save()
+	if err != nil {
+		buckets[name] = b
+	}
+	return err
+}
+
+// isEmpty 判断存储桶中是否没有任何对象版本; 只剩删除标记的对象的历史版本仍可按版本号读取,
+// 删除存储桶后再创建同名存储桶时这些版本会重新出现, 因此同样不算空
+func isEmpty(name string) (bool, error) {
+	exists, err := metadata.HasVersions(name + "/")
+	return !exists, err
+}
+
+// Hold 查找对象 <bucket>/<key> 所在的存储桶并阻止其被删除, 直到调用返回的 release,
+// 用于写入对象的元数据, 保证写入的版本不会留在已删除的存储桶中; 存储桶不存在时返回 ErrNotFound
+//
+// 持有期间不能调用本包中其他加锁的函数
+func Hold(object string) (func(), error) {
+	bucket, _, found := strings.Cut(object, "/")
+	if !found {
+		return func() {}, nil
+	}
+	load()
+	mutex.RLock()
+	if _, ok := buckets[bucket]; !ok {
+		mutex.RUnlock()
+		return nil, ErrNotFound
+	}
+	return mutex.RUnlock, nil
+}
@@ -0,0 +1,133 @@
+package buckets
+
+import (
+	"dot/v2/apiserver/objectstream"
+	"dot/v2/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Handler 存储桶管理接口
+//
+//	GET    /buckets/     列出所有存储桶
+//	GET    /buckets/<b>  查看存储桶及其策略
+//	PUT    /buckets/<b>  创建存储桶或修改已有存储桶的策略, 请求体为 JSON: {"scheme", "maxSize", "versioning", "compression"},
+//	                     省略的字段保持原值, 新建存储桶默认开启版本控制; 开启压缩后 Range 读取需从头解压, 见 Bucket.Compression
+//	DELETE /buckets/<b>  删除空的存储桶
+//
+// 系统内部使用的存储桶(以 '.' 开头)不列出, 也不能查看, 修改与删除
+func Handler(w http.ResponseWriter, r *http.Request) {
+	name := strings.Split(r.URL.EscapedPath(), "/")[2]
+	method := r.Method
+	if method == http.MethodGet && name == "" {
+		list := List()
+		result := make([]Bucket, 0, len(list))
+		for _, b := range list {
+			if !Reserved(b.Name) {
+				result = append(result, b)
+			}
+		}
+		writeJSON(w, http.StatusOK, result)
+		return
+	}
+	if Reserved(name) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if method == http.MethodGet {
+		b, ok := Get(name)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, b)
+		return
+	}
+	if method == http.MethodPut {
+		put(w, r, name)
+		return
+	}
+	if method == http.MethodDelete {
+		del(w, name)
+		return
+	}
+	w.WriteHeader(http.StatusMethodNotAllowed)
+}
+
+func put(w http.ResponseWriter, r *http.Request, name string) {
+	if !ValidName(name) {
+		log.Printf("invalid bucket name %q", name)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	b, exists := Get(name)
+	if !exists {
+		b = Bucket{Name: name, Versioning: true}
+	}
+	body, err := io.ReadAll(r.Body)
+	if err == nil && len(body) != 0 {
+		err = json.Unmarshal(body, &b)
+	}
+	if err == nil && b.MaxSize < 0 {
+		err = errors.New("negative maxSize")
+	}
+	if err == nil && b.Scheme != "" {
+		_, err = objectstream.ParseScheme(b.Scheme)
+	}
+	if err == nil && !utils.ValidCompression(b.Compression) {
+		err = fmt.Errorf("unsupported compression %q", b.Compression)
+	}
+	if err != nil {
+		log.Printf("invalid bucket policy %q: %v", body, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	b.Name = name
+
+	code := http.StatusOK
+	if exists {
+		b, err = Update(b)
+	} else {
+		b, err = Create(b)
+		code = http.StatusCreated
+	}
+	if errors.Is(err, ErrExists) || errors.Is(err, ErrNotFound) {
+		// 并发创建或删除了同名存储桶
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, code, b)
+}
+
+func del(w http.ResponseWriter, name string) {
+	err := Delete(name)
+	if errors.Is(err, ErrNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, ErrNotEmpty) {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	b, _ := json.Marshal(v)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(b)
+}
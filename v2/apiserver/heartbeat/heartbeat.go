@@ -1,7 +1,12 @@
 package heartbeat
 
 import (
+	"context"
 	"dot/v2/rabbitmq"
+	"dot/v2/types"
+	"encoding/json"
+	"errors"
+	"log"
 	"math/rand"
 	"os"
 	"strconv"
@@ -9,33 +14,70 @@ import (
 	"time"
 )
  
-var dataServers = make(map[string]time.Time)
+// dataServer 数据服务最近一次上报的心跳
+type dataServer struct {
+	types.Heartbeat
+	lastSeen time.Time
+}
+ 
+var dataServers = make(map[string]dataServer)
 var mutex sync.Mutex
  
+// ListenHeartbeat 接收数据服务通过 apiServers 交换机广播的心跳, RabbitMQ 重启后自动重连继续接收
 func ListenHeartbeat() {
-	server := os.Getenv("RABBITMQ_SERVER")
-	q := rabbitmq.New(server)
+	ctx := context.Background()
+	q, err := rabbitmq.Dial(ctx, rabbitmq.NodeQueue(rabbitmq.ApiServersExchange, types.NodeId()))
+	if err != nil {
+		log.Printf("Heartbeat listener stopped: %v", err)
+		return
+	}
 	defer q.Close()
-	q.Bind("apiServers")
-	c := q.Consume()
+	// 绑定失败时会在重连后重试
+	if err := q.Bind(ctx, rabbitmq.ApiServersExchange); err != nil {
+		log.Printf("Failed to bind apiServers: %v", err)
+	}
+	c, err := q.Consume(ctx)
+	if err != nil {
+		log.Printf("Heartbeat listener stopped: %v", err)
+		return
+	}
 	go removeExpiredDataServer()
 	for msg := range c {
-		dataServer, err := strconv.Unquote(string(msg.Body))
+		hb, version, err := parseHeartbeat(msg.Body)
 		if err != nil {
-			panic(err)
+			log.Printf("invalid heartbeat %q: %v", msg.Body, err)
+			continue
 		}
+		// 定位请求以所有数据服务都能读取的格式版本发送
+		types.ObservePeer(hb.Addr, max(version, hb.MessageVersion))
 		mutex.Lock()
-		dataServers[dataServer] = time.Now()
+		dataServers[hb.Addr] = dataServer{hb, time.Now()}
 		mutex.Unlock()
 	}
 }
  
+// parseHeartbeat 解析心跳消息, 返回心跳与消息的格式版本, 兼容旧版本数据服务只上报地址字符串的格式
+func parseHeartbeat(body []byte) (types.Heartbeat, int, error) {
+	var hb types.Heartbeat
+	var env types.Envelope
+	var err error
+	if len(body) > 0 && body[0] == '"' {
+		err = json.Unmarshal(body, &hb.Addr)
+	} else {
+		env, err = types.OpenMessage(body, types.HeartbeatMessage, &hb)
+	}
+	if err == nil && hb.Addr == "" {
+		err = errors.New("missing data server address")
+	}
+	return hb, env.Version, err
+}
+ 
 func removeExpiredDataServer() {
 	for {
 		time.Sleep(5 * time.Second)
 		mutex.Lock()
-		for s, t := range dataServers {
-			if t.Add(10 * time.Second).Before(time.Now()) {
+		for s, ds := range dataServers {
+			if ds.lastSeen.Add(10 * time.Second).Before(time.Now()) {
 				delete(dataServers, s)
 			}
 		}
@@ -54,10 +96,70 @@ func GetDataServers() []string {
 }
  
 func ChooseRandomDataServer() string {
-	ds := GetDataServers()
-	n := len(ds)
-	if n == 0 {
+	ds := ChooseRandomDataServers(1, nil)
+	if len(ds) == 0 {
 		return ""
 	}
-	return ds[rand.Intn(n)]
-}
\ No newline at end of file
+	return ds[0]
+}
+ 
+// ChooseRandomDataServers 随机选择至多 n 个不在 exclude 中的数据服务, 可用数据服务不足时返回的数量少于 n
+//
+// 磁盘接近写满的数据服务不参与选择; 优先选择与 exclude 及已选数据服务不同可用区的数据服务,
+// 使副本或分片分散在不同的可用区
+func ChooseRandomDataServers(n int, exclude []string) []string {
+	mutex.Lock()
+	usedZones := make(map[string]bool)
+	excluded := make(map[string]bool)
+	for _, s := range exclude {
+		excluded[s] = true
+		if ds, ok := dataServers[s]; ok {
+			usedZones[ds.Zone] = true
+		}
+	}
+	candidates := make([]types.Heartbeat, 0)
+	for s, ds := range dataServers {
+		if !excluded[s] && !nearlyFull(ds.Heartbeat) {
+			candidates = append(candidates, ds.Heartbeat)
+		}
+	}
+	mutex.Unlock()
+
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+	chosen := make([]string, 0, n)
+	picked := make(map[string]bool)
+	for _, c := range candidates {
+		if len(chosen) == n {
+			break
+		}
+		if !usedZones[c.Zone] {
+			usedZones[c.Zone] = true
+			picked[c.Addr] = true
+			chosen = append(chosen, c.Addr)
+		}
+	}
+	// 可用区数量不足时, 再从剩余的数据服务中补足
+	for _, c := range candidates {
+		if len(chosen) == n {
+			break
+		}
+		if !picked[c.Addr] {
+			chosen = append(chosen, c.Addr)
+		}
+	}
+	return chosen
+}
+ 
+// nearlyFull 磁盘剩余空间比例低于 MIN_FREE_RATIO (默认 0.05) 时视为接近写满, 未上报容量的数据服务不受限制
+func nearlyFull(hb types.Heartbeat) bool {
+	if hb.TotalBytes == 0 {
+		return false
+	}
+	ratio, err := strconv.ParseFloat(os.Getenv("MIN_FREE_RATIO"), 64)
+	if err != nil || ratio < 0 {
+		ratio = 0.05
+	}
+	return float64(hb.FreeBytes) < float64(hb.TotalBytes)*ratio
+}
@@ -1,44 +1,116 @@
 package locate
 
 import (
-	"dot/v2/rabbitmq"
+	"context"
+	"dot/v2/types"
 	"encoding/json"
+	"log"
 	"net/http"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 )
  
+// Handler 定位对象: GET /locate/<name>?expected=N, 返回 数据服务 -> 分片id列表 的 JSON,
+// 完整对象的 id 为 -1, 未指定 expected 时等待至超时以收集所有回复
 func Handler(w http.ResponseWriter, r *http.Request) {
 	m := r.Method
 	if m != http.MethodGet {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
-	info := Locate(strings.Split(r.URL.EscapedPath(), "/")[2])
+	expected := 0
+	if e := r.URL.Query().Get("expected"); e != "" {
+		var err error
+		expected, err = strconv.Atoi(e)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	info := LocateAll(strings.Split(r.URL.EscapedPath(), "/")[2], expected, Timeout())
 	if len(info) == 0 {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 	b, _ := json.Marshal(info)
+	w.Header().Set("Content-Type", "application/json")
 	w.Write(b)
 }
  
+// Timeout 等待定位回复的超时时间, 由 LOCATE_TIMEOUT 配置, 默认 1s
+func Timeout() time.Duration {
+	timeout, err := time.ParseDuration(os.Getenv("LOCATE_TIMEOUT"))
+	if err != nil || timeout <= 0 {
+		return time.Second
+	}
+	return timeout
+}
+ 
+// Locate 定位完整对象所在的数据服务
 func Locate(name string) string {
-	server := os.Getenv("RABBITMQ_SERVER")
-	q := rabbitmq.New(server)
-	q.Publish("dataServers", name)
-	c := q.Consume()
-	go func() {
-		time.Sleep(time.Second)
-		q.Close()
-	}()
-	msg := <-c
-	s, _ := strconv.Unquote(string(msg.Body))
-	return s
+	servers := LocateReplicas(name, 1)
+	if len(servers) == 0 {
+		return ""
+	}
+	return servers[0]
+}
+ 
+// LocateReplicas 定位保存了完整对象的所有数据服务
+func LocateReplicas(name string, expected int) []string {
+	servers := make([]string, 0)
+	for server, ids := range LocateAll(name, expected, Timeout()) {
+		if slices.Contains(ids, types.WholeObject) {
+			servers = append(servers, server)
+		}
+	}
+	return servers
+}
+ 
+// LocateShards 定位对象的所有纠删码分片, 返回 分片id -> 数据服务
+func LocateShards(name string, expected int) map[int]string {
+	shards := make(map[int]string)
+	for server, ids := range LocateAll(name, expected, Timeout()) {
+		for _, id := range ids {
+			if id != types.WholeObject {
+				shards[id] = server
+			}
+		}
+	}
+	return shards
+}
+ 
+// LocateAll 向所有数据服务广播定位请求, 返回 数据服务 -> 分片id列表(完整对象为 types.WholeObject),
+// 同一个数据服务可能同时保存了完整对象与分片, 或者修复后保存了多个分片;
+// 收到 expected 个不同的 (数据服务, 分片id) 回复后提前返回, expected <= 0 时一直等待至超时
+func LocateAll(name string, expected int, timeout time.Duration) map[string][]int {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	info := make(map[string][]int)
+	n := 0
+	c, stop, err := request(ctx, name)
+	if err != nil {
+		log.Printf("locate %s fail: %v", name, err)
+		return info
+	}
+	defer stop()
+	for expected <= 0 || n < expected {
+		select {
+		case m := <-c:
+			if !slices.Contains(info[m.Addr], m.Id) {
+				info[m.Addr] = append(info[m.Addr], m.Id)
+				n++
+			}
+		case <-ctx.Done():
+			// 超时
+			return info
+		}
+	}
+	return info
 }
  
 func Exist(name string) bool {
-	return Locate(name) != ""
-}
\ No newline at end of file
+	return len(LocateAll(name, 1, Timeout())) != 0
+}
@@ -0,0 +1,200 @@
+package locate
+
+import (
+	"context"
+	"dot/v2/rabbitmq"
+	"dot/v2/types"
+	"errors"
+	"log"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 定位请求共用一个长期的连接与匿名回复队列, 每个请求带有不同的关联 id,
+// 数据服务的回复按关联 id 分发给等待的调用方, 不再为每次定位建立连接与队列
+var (
+	replies      rabbitmq.Bus
+	repliesMutex sync.RWMutex
+	repliesOnce  sync.Once
+	repliesReady = make(chan struct{})
+
+	waitMutex sync.Mutex
+	waiting   = make(map[string]chan types.LocateMessage) // 关联 id -> 等待回复的调用方
+	lastId    atomic.Uint64
+
+	// lastUncorrelated 最近一次收到不带关联 id 的回复的时间, 为 0 表示没有收到过
+	lastUncorrelated atomic.Int64
+)
+
+// replyBuffer 每个定位请求缓存的回复数, 调用方来不及读取时多出的回复被丢弃
+const replyBuffer = 64
+
+// retryInterval 建立回复队列失败后重试的间隔
+const retryInterval = time.Second
+
+// legacyWindow 收到不带关联 id 的回复后的这段时间内, 定位请求改用各自的匿名回复队列
+//
+// 升级前的数据服务回复时不带关联 id, 共用的回复队列无法判断回复属于哪个请求;
+// 所有数据服务升级后不再收到这样的回复, 超过这段时间后恢复使用共用的回复队列
+const legacyWindow = 10 * time.Minute
+
+// listenReplies 建立共用的连接并分发回复, 建立失败或连接关闭后一直重试,
+// 第一次建立成功后关闭 repliesReady
+func listenReplies() {
+	ctx := context.Background()
+	ready := false
+	for {
+		q, msgs, err := openReplies(ctx)
+		if err != nil {
+			log.Printf("Failed to listen for locate replies, retrying: %v", err)
+			time.Sleep(retryInterval)
+			continue
+		}
+		repliesMutex.Lock()
+		replies = q
+		repliesMutex.Unlock()
+		if !ready {
+			close(repliesReady)
+			ready = true
+		}
+		for msg := range msgs {
+			dispatch(msg)
+		}
+		q.Close()
+		log.Println("Locate reply listener closed, reconnecting")
+	}
+}
+
+func openReplies(ctx context.Context) (rabbitmq.Bus, <-chan rabbitmq.Message, error) {
+	q, err := rabbitmq.Dial(ctx, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	msgs, err := q.Consume(ctx)
+	if err != nil {
+		q.Close()
+		return nil, nil, err
+	}
+	return q, msgs, nil
+}
+
+// parseReply 解析定位回复
+func parseReply(msg rabbitmq.Message) (types.LocateMessage, error) {
+	var m types.LocateMessage
+	_, err := types.OpenMessage(msg.Body, types.LocateReply, &m)
+	if err == nil && m.Addr == "" {
+		err = errors.New("missing data server address")
+	}
+	return m, err
+}
+
+// dispatch 将回复交给关联 id 对应的调用方, 已超时的请求的回复被忽略;
+// 不带关联 id 的回复来自升级前的数据服务, 无法确定属于哪个请求(也可能属于已超时的请求), 丢弃后
+// 之后的定位请求改用各自的回复队列, 见 legacyWindow
+func dispatch(msg rabbitmq.Message) {
+	m, err := parseReply(msg)
+	if err != nil {
+		log.Printf("invalid locate message %q: %v", msg.Body, err)
+		return
+	}
+	id := msg.CorrelationId
+	if id == "" {
+		if lastUncorrelated.Swap(time.Now().UnixNano()) == 0 {
+			log.Printf("locate reply from %s has no correlation id, using a reply queue per request for %v", m.Addr, legacyWindow)
+		}
+		return
+	}
+	waitMutex.Lock()
+	defer waitMutex.Unlock()
+	c, ok := waiting[id]
+	if !ok {
+		return
+	}
+	select {
+	case c <- m:
+	default:
+		log.Printf("too many replies to locate request %s, dropped reply from %s", id, m.Addr)
+	}
+}
+
+// legacyPeers 最近是否收到过不带关联 id 的回复
+func legacyPeers() bool {
+	t := lastUncorrelated.Load()
+	return t != 0 && time.Since(time.Unix(0, t)) < legacyWindow
+}
+
+// request 广播定位 name 的请求, 返回接收回复的通道, 调用方结束等待后需调用返回的 stop
+func request(ctx context.Context, name string) (<-chan types.LocateMessage, func(), error) {
+	body, err := types.SealMessage(types.SendVersion(), types.LocateRequest, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if legacyPeers() {
+		return requestOwnQueue(ctx, body)
+	}
+	repliesOnce.Do(func() { go listenReplies() })
+	select {
+	case <-repliesReady:
+	case <-ctx.Done():
+		return nil, nil, errors.New("not connected to RabbitMQ")
+	}
+	id := strconv.FormatUint(lastId.Add(1), 10)
+	c := make(chan types.LocateMessage, replyBuffer)
+	waitMutex.Lock()
+	waiting[id] = c
+	waitMutex.Unlock()
+	stop := func() { done(id) }
+	repliesMutex.RLock()
+	q := replies
+	repliesMutex.RUnlock()
+	if err := q.Request(ctx, rabbitmq.DataServersExchange, id, body); err != nil {
+		stop()
+		return nil, nil, err
+	}
+	return c, stop, nil
+}
+
+// requestOwnQueue 以单独的匿名回复队列广播请求, 队列中的所有回复都属于这个请求, 不论是否带有关联 id;
+// 请求仍然带有关联 id, 以便发现升级前的数据服务是否还在回复
+func requestOwnQueue(ctx context.Context, body any) (<-chan types.LocateMessage, func(), error) {
+	q, err := rabbitmq.Open(ctx, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	msgs, err := q.Consume(ctx)
+	if err == nil {
+		err = q.Request(ctx, rabbitmq.DataServersExchange, strconv.FormatUint(lastId.Add(1), 10), body)
+	}
+	if err != nil {
+		q.Close()
+		return nil, nil, err
+	}
+	c := make(chan types.LocateMessage, replyBuffer)
+	go func() {
+		for msg := range msgs {
+			m, err := parseReply(msg)
+			if err != nil {
+				log.Printf("invalid locate message %q: %v", msg.Body, err)
+				continue
+			}
+			if msg.CorrelationId == "" {
+				lastUncorrelated.Store(time.Now().UnixNano())
+			}
+			select {
+			case c <- m:
+			default:
+				log.Printf("too many replies to locate request, dropped reply from %s", m.Addr)
+			}
+		}
+	}()
+	return c, q.Close, nil
+}
+
+// done 结束关联 id 的等待, 之后收到的回复被忽略
+func done(id string) {
+	waitMutex.Lock()
+	defer waitMutex.Unlock()
+	delete(waiting, id)
+}
@@ -0,0 +1,77 @@
+package locate
+
+import (
+	"context"
+	"dot/v2/rabbitmq"
+	"dot/v2/types"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDataServer 以完整对象回复所有定位请求; legacy 为 true 时模拟升级前的数据服务, 回复不带关联 id
+func fakeDataServer(t *testing.T, addr string, legacy bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	q, err := rabbitmq.Open(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Bind(ctx, rabbitmq.DataServersExchange); err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := q.Consume(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for msg := range msgs {
+			var object string
+			if _, err := types.OpenMessage(msg.Body, types.LocateRequest, &object); err != nil {
+				continue
+			}
+			reply := types.LocateMessage{Addr: addr, Id: types.WholeObject}
+			if legacy {
+				q.Send(ctx, msg.ReplyTo, reply)
+			} else {
+				q.Reply(ctx, msg, reply)
+			}
+		}
+	}()
+}
+
+func TestLocateReplies(t *testing.T) {
+	rabbitmq.SetTransport(rabbitmq.NewMemory())
+	fakeDataServer(t, "new:1", false)
+
+	// 只有升级后的数据服务时使用共用的回复队列
+	if got := LocateAll("a", 1, time.Second); len(got) != 1 {
+		t.Fatalf("located %v, want new:1", got)
+	}
+	if legacyPeers() {
+		t.Fatal("legacy peers detected without uncorrelated replies")
+	}
+
+	// 升级前的数据服务的回复不带关联 id, 无法确定属于哪个请求, 被丢弃
+	fakeDataServer(t, "old:1", true)
+	if got := LocateAll("b", 2, 200*time.Millisecond); len(got) != 1 || got["new:1"] == nil {
+		t.Fatalf("located %v, want only new:1", got)
+	}
+	if !legacyPeers() {
+		t.Fatal("uncorrelated reply not detected")
+	}
+
+	// 之后并发的请求各自使用匿名回复队列, 都能收到两个数据服务的回复
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			if got := LocateAll(name, 2, time.Second); len(got) != 2 {
+				t.Errorf("located %s on %v, want new:1 and old:1", name, got)
+			}
+		}(fmt.Sprint("c", i))
+	}
+	wg.Wait()
+}
@@ -1,9 +1,14 @@
-package apiserver
+package main
 
 import (
+	"dot/v2/apiserver/buckets"
 	"dot/v2/apiserver/heartbeat"
 	"dot/v2/apiserver/locate"
-	"dot/v2/objects"
+	"dot/v2/apiserver/objects"
+	"dot/v2/apiserver/rebuild"
+	"dot/v2/apiserver/repair"
+	"dot/v2/apiserver/s3"
+	"dot/v2/apiserver/versions"
 	"log"
 	"net/http"
 	"os"
@@ -15,9 +20,23 @@ func main() {
 	if err := godotenv.Load("/home/raymond/桌面/expr/Distri_OSS_Tutorial/v2/.env"); err != nil {
 		log.Print(err)
 	}
+	// 清理上次运行遗留的暂存文件
+	objects.SweepSpool()
 	go heartbeat.ListenHeartbeat()
+	go repair.ListenReports()
+	http.HandleFunc("/buckets/", buckets.Handler)
+	http.HandleFunc("/objects", objects.Handler)
 	http.HandleFunc("/objects/", objects.Handler)
+	http.HandleFunc("/uploads/", objects.UploadsHandler)
 	http.HandleFunc("/locate/", locate.Handler)
+	http.HandleFunc("/versions/", versions.Handler)
+	http.HandleFunc("/rebuild", rebuild.Handler)
+	// S3 兼容接口使用单独的端口, 未配置 S3_LISTEN_ADDRESS 时不启动
+	if s3Address := os.Getenv("S3_LISTEN_ADDRESS"); s3Address != "" {
+		go func() {
+			log.Println(http.ListenAndServe(s3Address, http.HandlerFunc(s3.Handler)))
+		}()
+	}
 	address := os.Getenv("LISTEN_ADDRESS")
 	err := http.ListenAndServe(address, nil)
 	if err != nil {
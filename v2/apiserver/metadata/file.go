@@ -0,0 +1,267 @@
+package metadata
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileStore 基于本地文件的元数据服务
+//
+// 所有版本记录以 JSON 行的形式追加写入 versions.log, 启动时重放日志重建内存索引,
+// 同一 (name, version) 的后写记录覆盖先写记录
+type FileStore struct {
+	mutex    sync.RWMutex
+	file     *os.File
+	versions map[string][]Metadata
+	names    []string           // 按顺序排列的对象名, 用于列出对象
+	hashes   map[string]hashRef // 散列值 -> 引用该散列值的版本, 用于 HashReferenced 与 SearchHash
+}
+
+// hashRef 引用同一散列值的版本数与其中最近写入的一个版本
+type hashRef struct {
+	count  int
+	latest Metadata
+}
+
+// NewFileStore 打开(或创建) root 目录下的元数据文件
+//
+// 崩溃时最后一条记录可能只写入了一部分(没有结尾的换行), 这样的记录没有写入成功, 截断后继续启动;
+// 其他无法解析的记录说明文件已损坏, 返回错误
+func NewFileStore(root string) (*FileStore, error) {
+	err := os.MkdirAll(root, 0755)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(root, "versions.log"), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s := &FileStore{file: f, versions: make(map[string][]Metadata), hashes: make(map[string]hashRef)}
+	r := bufio.NewReaderSize(f, 64*1024)
+	offset := int64(0)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF && len(line) != 0 {
+			log.Printf("Truncating partial metadata record at offset %d: %q", offset, line)
+			if err := f.Truncate(offset); err != nil {
+				f.Close()
+				return nil, err
+			}
+		}
+		if err == io.EOF {
+			return s, nil
+		}
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		var m Metadata
+		if err := json.Unmarshal(line, &m); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("corrupted metadata record at offset %d %q: %w", offset, line, err)
+		}
+		s.apply(m)
+		offset += int64(len(line))
+	}
+}
+
+// apply 将一条记录写入内存索引, 调用方需持有写锁
+func (s *FileStore) apply(m Metadata) {
+	vs := s.versions[m.Name]
+	if len(vs) == 0 {
+		j := sort.SearchStrings(s.names, m.Name)
+		s.names = append(s.names, "")
+		copy(s.names[j+1:], s.names[j:])
+		s.names[j] = m.Name
+	}
+	i := sort.Search(len(vs), func(i int) bool { return vs[i].Version >= m.Version })
+	if i < len(vs) && vs[i].Version == m.Version {
+		old := vs[i]
+		vs[i] = m
+		s.unref(old)
+		s.ref(m)
+		return
+	}
+	vs = append(vs, Metadata{})
+	copy(vs[i+1:], vs[i:])
+	vs[i] = m
+	s.versions[m.Name] = vs
+	s.ref(m)
+}
+
+// ref 记录版本 m 对散列值的引用, 删除标记没有散列值, 调用方需持有写锁
+func (s *FileStore) ref(m Metadata) {
+	if m.Hash == "" {
+		return
+	}
+	r := s.hashes[m.Hash]
+	r.count++
+	r.latest = m
+	s.hashes[m.Hash] = r
+}
+
+// unref 去掉被覆盖的版本 old 对散列值的引用, 调用方需持有写锁且 old 已从 versions 中替换
+func (s *FileStore) unref(old Metadata) {
+	r, ok := s.hashes[old.Hash]
+	if !ok {
+		return
+	}
+	r.count--
+	if r.count == 0 {
+		delete(s.hashes, old.Hash)
+		return
+	}
+	// 覆盖已有版本很少见, 此时才查找另一个引用该散列值的版本
+	if r.latest.Name == old.Name && r.latest.Version == old.Version {
+		for _, vs := range s.versions {
+			for _, v := range vs {
+				if v.Hash == old.Hash {
+					r.latest = v
+				}
+			}
+		}
+	}
+	s.hashes[old.Hash] = r
+}
+
+// persist 追加写入一条记录, 调用方需持有写锁
+func (s *FileStore) persist(m Metadata) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = s.file.Write(append(b, '\n'))
+	if err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+func (s *FileStore) SearchLatestVersion(name string) (Metadata, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	vs := s.versions[name]
+	if len(vs) == 0 {
+		return Metadata{}, nil
+	}
+	return vs[len(vs)-1], nil
+}
+
+func (s *FileStore) GetMetadata(name string, version int) (Metadata, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	vs := s.versions[name]
+	i := sort.Search(len(vs), func(i int) bool { return vs[i].Version >= version })
+	if i < len(vs) && vs[i].Version == version {
+		return vs[i], nil
+	}
+	return Metadata{}, fmt.Errorf("object %s version %d not found", name, version)
+}
+
+func (s *FileStore) AddVersion(m Metadata) (Metadata, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	m.Version = 1
+	if vs := s.versions[m.Name]; len(vs) != 0 {
+		m.Version = vs[len(vs)-1].Version + 1
+	}
+	if m.Time.IsZero() {
+		m.Time = time.Now().UTC()
+	}
+	if err := s.persist(m); err != nil {
+		return Metadata{}, err
+	}
+	s.apply(m)
+	return m, nil
+}
+
+func (s *FileStore) PutMetadata(m Metadata) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if m.Time.IsZero() {
+		m.Time = time.Now().UTC()
+	}
+	if err := s.persist(m); err != nil {
+		return err
+	}
+	s.apply(m)
+	return nil
+}
+
+func (s *FileStore) SearchLatestVersions(prefix, marker string, size int) ([]Metadata, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	result := make([]Metadata, 0)
+	i := sort.SearchStrings(s.names, prefix)
+	if marker >= prefix {
+		i = sort.Search(len(s.names), func(i int) bool { return s.names[i] > marker })
+	}
+	for ; i < len(s.names) && strings.HasPrefix(s.names[i], prefix); i++ {
+		vs := s.versions[s.names[i]]
+		if latest := vs[len(vs)-1]; !latest.IsDeleteMarker() {
+			result = append(result, latest)
+		}
+		if size > 0 && len(result) == size {
+			break
+		}
+	}
+	return result, nil
+}
+
+func (s *FileStore) SearchAllVersions(name string, from, size int) ([]Metadata, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	var all []Metadata
+	if name != "" {
+		all = s.versions[name]
+	} else {
+		names := make([]string, 0, len(s.versions))
+		for n := range s.versions {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		for _, n := range names {
+			all = append(all, s.versions[n]...)
+		}
+	}
+	if from >= len(all) {
+		return []Metadata{}, nil
+	}
+	end := len(all)
+	if size > 0 && from+size < end {
+		end = from + size
+	}
+	result := make([]Metadata, end-from)
+	copy(result, all[from:end])
+	return result, nil
+}
+
+func (s *FileStore) HasVersions(prefix string) (bool, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	i := sort.SearchStrings(s.names, prefix)
+	return i < len(s.names) && strings.HasPrefix(s.names[i], prefix), nil
+}
+
+func (s *FileStore) HashReferenced(hash string) (bool, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	// 删除标记之前的历史版本仍可按版本号读取, 同样算作引用
+	_, ok := s.hashes[hash]
+	return ok, nil
+}
+
+func (s *FileStore) SearchHash(hash string) (Metadata, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.hashes[hash].latest, nil
+}
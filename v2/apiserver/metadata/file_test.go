@@ -0,0 +1,91 @@
+package metadata
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func openStore(t *testing.T, root string) *FileStore {
+	s, err := NewFileStore(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.file.Close() })
+	return s
+}
+
+// TestFileStoreTornRecord 崩溃时只写入一部分的最后一条记录被截断, 文件中间的损坏记录拒绝启动
+func TestFileStoreTornRecord(t *testing.T) {
+	root := t.TempDir()
+	s := openStore(t, root)
+	for _, hash := range []string{"h1", "h2"} {
+		if _, err := s.AddVersion(Metadata{Name: "a", Hash: hash}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.file.Close()
+	log := filepath.Join(root, "versions.log")
+	b, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	torn := append(append([]byte{}, b...), `{"name":"a","version":3,"ha`...)
+	if err := os.WriteFile(log, torn, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openStore(t, root)
+	if latest, _ := s.SearchLatestVersion("a"); latest.Version != 2 || latest.Hash != "h2" {
+		t.Errorf("latest version after torn record = %+v, want version 2", latest)
+	}
+	if m, err := s.AddVersion(Metadata{Name: "a", Hash: "h3"}); err != nil || m.Version != 3 {
+		t.Fatalf("add version after torn record = %+v, %v", m, err)
+	}
+	s.file.Close()
+	if s = openStore(t, root); len(s.versions["a"]) != 3 {
+		t.Errorf("reopened store has %d versions, want 3", len(s.versions["a"]))
+	}
+	s.file.Close()
+
+	corrupted := append([]byte(`{"name":"a","ver`+"\n"), b...)
+	if err := os.WriteFile(log, corrupted, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFileStore(root); err == nil {
+		t.Error("opened store with a corrupted record in the middle")
+	}
+}
+
+// TestFileStoreHashes 散列值索引随新增与覆盖版本更新
+func TestFileStoreHashes(t *testing.T) {
+	s := openStore(t, t.TempDir())
+	s.AddVersion(Metadata{Name: "a", Hash: "h1", Size: 1})
+	s.AddVersion(Metadata{Name: "b", Hash: "h1", Size: 1})
+	s.AddVersion(Metadata{Name: "b"})
+	if ok, _ := s.HashReferenced("h1"); !ok {
+		t.Error("h1 not referenced")
+	}
+	if ok, _ := s.HashReferenced(""); ok {
+		t.Error("delete marker referenced by hash")
+	}
+	if m, _ := s.SearchHash("h1"); m.Name != "b" || m.Version != 1 {
+		t.Errorf("search h1 = %+v, want b version 1", m)
+	}
+
+	// 覆盖 b 的版本 1 后仍由 a 引用 h1
+	s.PutMetadata(Metadata{Name: "b", Version: 1, Hash: "h2", Size: 2})
+	if m, _ := s.SearchHash("h1"); m.Name != "a" || m.Version != 1 {
+		t.Errorf("search h1 after overwrite = %+v, want a version 1", m)
+	}
+	s.PutMetadata(Metadata{Name: "a", Version: 1, Hash: "h2", Size: 2})
+	if ok, _ := s.HashReferenced("h1"); ok {
+		t.Error("h1 still referenced after all versions were overwritten")
+	}
+	if m, _ := s.SearchHash("h1"); m.Version != 0 {
+		t.Errorf("search h1 = %+v, want none", m)
+	}
+	if m, _ := s.SearchHash("h2"); m.Hash != "h2" || m.Size != 2 {
+		t.Errorf("search h2 = %+v", m)
+	}
+}
@@ -0,0 +1,64 @@
+package metadata
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxListSize 一次最多列出的对象与公共前缀数量
+const MaxListSize = 1000
+
+// ListResult 对象列表
+type ListResult struct {
+	Objects        []Metadata `json:"objects"`
+	CommonPrefixes []string   `json:"commonPrefixes"`
+	IsTruncated    bool       `json:"isTruncated"`
+	// NextMarker 列表被截断时, 作为下一次请求的 marker 继续列出
+	NextMarker string `json:"nextMarker,omitempty"`
+}
+
+// List 按名字顺序列出以 prefix 开头, 名字大于 marker 的对象的最新版本, 最多 limit 项;
+// delimiter 不为空时, prefix 之后包含 delimiter 的对象合并为一个公共前缀, 计为一项
+func List(prefix, delimiter, marker string, limit int) (ListResult, error) {
+	result := ListResult{Objects: []Metadata{}, CommonPrefixes: []string{}}
+	if limit <= 0 || limit > MaxListSize {
+		limit = MaxListSize
+	}
+	from, last := marker, ""
+	for {
+		metas, err := SearchLatestVersions(prefix, from, MaxListSize)
+		if err != nil {
+			return result, err
+		}
+		more := len(metas) == MaxListSize
+		for _, m := range metas {
+			from = m.Name
+			entry, isPrefix := m.Name, false
+			if delimiter != "" {
+				if i := strings.Index(m.Name[len(prefix):], delimiter); i >= 0 {
+					entry, isPrefix = m.Name[:len(prefix)+i+len(delimiter)], true
+				}
+			}
+			if !isPrefix || entry != marker {
+				if len(result.Objects)+len(result.CommonPrefixes) == limit {
+					result.IsTruncated, result.NextMarker = true, last
+					return result, nil
+				}
+				if isPrefix {
+					result.CommonPrefixes = append(result.CommonPrefixes, entry)
+				} else {
+					result.Objects = append(result.Objects, m)
+				}
+				last = entry
+			}
+			if isPrefix {
+				// 跳过公共前缀下的其余对象
+				from, more = entry+string(utf8.MaxRune), true
+				break
+			}
+		}
+		if !more {
+			return result, nil
+		}
+	}
+}
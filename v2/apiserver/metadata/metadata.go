@@ -0,0 +1,147 @@
+package metadata
+
+import (
+	"dot/v2/encryption"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Metadata 对象某个版本的元数据
+type Metadata struct {
+	Name    string `json:"name"`
+	Version int    `json:"version"`
+	Size    int64  `json:"size"`
+	Hash    string `json:"hash"`
+	// Scheme 数据冗余策略, 如 single, rs-4-2, 为空表示单副本
+	Scheme string `json:"scheme,omitempty"`
+	// Compression 写入时要求数据服务使用的压缩算法, 如 gzip, zstd, 为空表示不压缩;
+	// 压缩后没有变小的数据按原样保存, 因此数据不一定是压缩的
+	Compression string `json:"compression,omitempty"`
+	// Time 版本的写入时间
+	Time time.Time `json:"time"`
+	// Headers 随版本保存的 X-Meta-* 用户元数据与 Content-Type, Content-Encoding, Cache-Control,
+	// 键为规范化的请求头名
+	Headers map[string]string `json:"headers,omitempty"`
+	// ETag S3 接口的 ETag(不含引号): 一般为数据 MD5 的十六进制值, 分段上传的对象为 <各分段 MD5 拼接后的 MD5>-<分段数>;
+	// 为空表示写入时没有计算 MD5(SSE-C 对象, 断点续传与旧版本), S3 接口以散列值代替
+	ETag string `json:"etag,omitempty"`
+	// CustomerKey 以客户提供的密钥(SSE-C)加密保存的版本的数据密钥, 此时 Size 与 Hash 为加密后数据的大小与散列值
+	CustomerKey *CustomerKey `json:"customerKey,omitempty"`
+}
+
+// CustomerKey 客户提供的密钥包装的数据密钥, 服务端只保存客户密钥的 MD5 用于校验读取时提供的密钥
+type CustomerKey struct {
+	KeyMD5     string `json:"keyMD5"` // base64 编码的客户密钥 MD5
+	WrappedKey []byte `json:"wrappedKey"`
+}
+
+// Store 元数据服务接口, 默认实现为内嵌的 FileStore, 也可替换为 Elasticsearch 等外部服务
+type Store interface {
+	// SearchLatestVersion 查找对象的最新版本, 对象不存在时返回零值
+	SearchLatestVersion(name string) (Metadata, error)
+	// GetMetadata 查找对象的指定版本
+	GetMetadata(name string, version int) (Metadata, error)
+	// AddVersion 为对象 m.Name 新增一个版本, 版本号为当前最新版本号加一, 返回写入的元数据
+	// m.Time 为零值时记录为当前时间
+	AddVersion(m Metadata) (Metadata, error)
+	// PutMetadata 写入对象的指定版本, 该版本已存在时覆盖, m.Time 为零值时记录为当前时间
+	PutMetadata(m Metadata) error
+	// SearchLatestVersions 按名字顺序列出以 prefix 开头, 名字大于 marker 且最新版本不是删除标记的对象,
+	// 最多 size 个, size <= 0 时不限制
+	SearchLatestVersions(prefix, marker string, size int) ([]Metadata, error)
+	// SearchAllVersions 按版本号顺序列出对象的历史版本, name 为空时列出所有对象
+	SearchAllVersions(name string, from, size int) ([]Metadata, error)
+	// HasVersions 判断是否有名字以 prefix 开头的对象, 包括最新版本为删除标记的对象
+	HasVersions(prefix string) (bool, error)
+	// HashReferenced 判断是否仍有版本引用该散列值, 包括删除标记之前的历史版本
+	HashReferenced(hash string) (bool, error)
+	// SearchHash 查找任意一个引用该散列值的版本, 用于获取数据的大小与冗余策略, 不存在时返回零值
+	SearchHash(hash string) (Metadata, error)
+}
+
+var (
+	store Store
+	once  sync.Once
+)
+
+// SetStore 替换默认的元数据服务, 需在处理请求之前调用
+func SetStore(s Store) {
+	once.Do(func() {})
+	store = s
+}
+
+// Root 元数据目录, 由 METADATA_ROOT 配置, 默认为 STORAGE_ROOT/metadata
+func Root() string {
+	root := os.Getenv("METADATA_ROOT")
+	if root == "" {
+		root = os.Getenv("STORAGE_ROOT") + "/metadata"
+	}
+	return root
+}
+
+func defaultStore() Store {
+	once.Do(func() {
+		root := Root()
+		s, err := NewFileStore(root)
+		if err != nil {
+			log.Fatalf("Failed to open metadata store %s: %v", root, err)
+		}
+		store = s
+	})
+	return store
+}
+
+// IsDeleteMarker 判断该版本是否为删除标记(tombstone), 删除标记的散列值为空
+func (m Metadata) IsDeleteMarker() bool {
+	return m.Hash == ""
+}
+
+// ObjectSize 客户端看到的对象大小, 以客户密钥加密保存的版本为解密后的大小
+func (m Metadata) ObjectSize() int64 {
+	if m.CustomerKey != nil {
+		return encryption.PlaintextSize(m.Size)
+	}
+	return m.Size
+}
+
+// GetMetadata 获取对象的元数据, version 为 0 时返回最新版本
+func GetMetadata(name string, version int) (Metadata, error) {
+	if version == 0 {
+		return defaultStore().SearchLatestVersion(name)
+	}
+	return defaultStore().GetMetadata(name, version)
+}
+
+func SearchLatestVersion(name string) (Metadata, error) {
+	return defaultStore().SearchLatestVersion(name)
+}
+
+func AddVersion(m Metadata) (Metadata, error) {
+	return defaultStore().AddVersion(m)
+}
+
+func PutMetadata(m Metadata) error {
+	return defaultStore().PutMetadata(m)
+}
+
+func SearchLatestVersions(prefix, marker string, size int) ([]Metadata, error) {
+	return defaultStore().SearchLatestVersions(prefix, marker, size)
+}
+
+func SearchAllVersions(name string, from, size int) ([]Metadata, error) {
+	return defaultStore().SearchAllVersions(name, from, size)
+}
+
+func HasVersions(prefix string) (bool, error) {
+	return defaultStore().HasVersions(prefix)
+}
+
+func HashReferenced(hash string) (bool, error) {
+	return defaultStore().HashReferenced(hash)
+}
+
+func SearchHash(hash string) (Metadata, error) {
+	return defaultStore().SearchHash(hash)
+}
@@ -0,0 +1,79 @@
+package objects
+
+import (
+	"dot/v2/apiserver/metadata"
+	"dot/v2/utils"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// errPreconditionFailed 条件写入时 If-Match 或 If-None-Match 不满足
+var errPreconditionFailed = errors.New("precondition failed")
+
+// etag 对象版本的 ETag, 即内容的散列值, 内容相同的版本 ETag 相同
+func etag(meta metadata.Metadata) string {
+	return `"` + meta.Hash + `"`
+}
+
+// encodedETag 以压缩保存的原始数据返回对象(Content-Encoding)时的 ETag, 与未压缩的表示区分
+func encodedETag(meta metadata.Metadata, encoding string) string {
+	return `"` + utils.CompressedFileName(meta.Hash, encoding) + `"`
+}
+
+// matchMeta 判断条件请求头是否匹配对象版本: 除 etag 外也接受 S3 接口返回的 MD5 ETag 与压缩表示的 ETag
+func matchMeta(header string, meta metadata.Metadata) bool {
+	if meta.Compression != "" && matchETag(header, encodedETag(meta, meta.Compression)) {
+		return true
+	}
+	return matchIdentity(header, meta)
+}
+
+// matchIdentity 与 matchMeta 相同, 但只接受未压缩表示的 ETag, 用于 If-Range
+func matchIdentity(header string, meta metadata.Metadata) bool {
+	return matchETag(header, etag(meta)) || (meta.ETag != "" && matchETag(header, `"`+meta.ETag+`"`))
+}
+
+// matchETag 判断 If-Match/If-None-Match 请求头中的 ETag 列表是否包含 tag, * 匹配任何存在的对象;
+// 散列值即内容, 因此弱 ETag W/"..." 与强 ETag 同样比较
+func matchETag(header, tag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == "*" || t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// conditional 请求是否带有 If-Match 或 If-None-Match
+func conditional(r *http.Request) bool {
+	return r.Header.Get("If-Match") != "" || r.Header.Get("If-None-Match") != ""
+}
+
+// checkRead 检查 GET/HEAD 的条件请求头: If-Match 不匹配时返回 412, If-None-Match 匹配时返回 304,
+// 条件满足时返回 200
+func checkRead(r *http.Request, meta metadata.Metadata) int {
+	if h := r.Header.Get("If-Match"); h != "" && !matchMeta(h, meta) {
+		return http.StatusPreconditionFailed
+	}
+	if h := r.Header.Get("If-None-Match"); h != "" && matchMeta(h, meta) {
+		return http.StatusNotModified
+	}
+	return http.StatusOK
+}
+
+// checkWrite 以对象当前的最新版本检查写入与删除的条件请求头, latest 为零值或删除标记表示对象不存在:
+//
+//	If-None-Match: *       只在对象不存在时写入
+//	If-Match: "<hash>"     只在最新版本的内容仍为 hash 时写入, 用于比较并交换, 避免并发写入互相覆盖
+func checkWrite(r *http.Request, latest metadata.Metadata) error {
+	exists := latest.Version != 0 && !latest.IsDeleteMarker()
+	if h := r.Header.Get("If-Match"); h != "" && (!exists || !matchMeta(h, latest)) {
+		return errPreconditionFailed
+	}
+	if h := r.Header.Get("If-None-Match"); h != "" && exists && matchMeta(h, latest) {
+		return errPreconditionFailed
+	}
+	return nil
+}
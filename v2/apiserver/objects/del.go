@@ -0,0 +1,112 @@
+package objects
+
+import (
+	"dot/v2/apiserver/heartbeat"
+	"dot/v2/apiserver/metadata"
+	"dot/v2/apiserver/objectstream"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// del 为对象写入删除标记, 若数据不再被任何版本引用则通知数据服务删除物理文件,
+// 带 If-Match 时只在最新版本的内容匹配时删除
+func del(w http.ResponseWriter, r *http.Request) {
+	b, name, code := objectName(r)
+	if code != http.StatusOK {
+		w.WriteHeader(code)
+		return
+	}
+	meta, err := metadata.SearchLatestVersion(name)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if meta.Version == 0 || meta.IsDeleteMarker() {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	replaced, err := addVersion(b, metadata.Metadata{Name: name}, r)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(writeStatus(err))
+		return
+	}
+	if replaced != "" {
+		removeData(replaced)
+	}
+	removeUnreferenced(name)
+}
+ 
+// removeUnreferenced 删除对象历史版本中已无引用的数据
+func removeUnreferenced(name string) {
+	metas, err := metadata.SearchAllVersions(name, 0, 0)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	checked := make(map[string]bool)
+	for _, m := range metas {
+		if m.IsDeleteMarker() || checked[m.Hash] {
+			continue
+		}
+		checked[m.Hash] = true
+		removeData(m.Hash)
+	}
+}
+
+// removeData 数据不再被任何版本引用时通知数据服务删除
+func removeData(hash string) {
+	unlock := lockHash(hash)
+	defer unlock()
+	removeDataLocked(hash)
+}
+
+// removeDataLocked 与 removeData 相同, 调用方已持有 hash 的锁
+func removeDataLocked(hash string) {
+	referenced, err := metadata.HashReferenced(hash)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if !referenced {
+		objectstream.DeleteObject(heartbeat.GetDataServers(), url.PathEscape(hash))
+	}
+}
+
+// hashLocks 同一散列值的数据的写入与回收互斥: 回收时检查引用与删除数据之间,
+// 不能有新版本引用即将删除的数据, 也不能有新写入的同名数据被删除
+var (
+	hashMutex sync.Mutex
+	hashLocks = make(map[string]*hashLock)
+)
+
+type hashLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lockHash 锁定散列值为 hash 的数据, 返回解锁函数; 不同散列值之间互不影响,
+// 持有一个散列值的锁时不要再锁定其它散列值
+func lockHash(hash string) func() {
+	hashMutex.Lock()
+	l := hashLocks[hash]
+	if l == nil {
+		l = &hashLock{}
+		hashLocks[hash] = l
+	}
+	l.refs++
+	hashMutex.Unlock()
+	l.Lock()
+	return func() {
+		l.Unlock()
+		hashMutex.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(hashLocks, hash)
+		}
+		hashMutex.Unlock()
+	}
+}
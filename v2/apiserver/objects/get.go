@@ -0,0 +1,179 @@
+package objects
+
+import (
+	"dot/v2/apiserver/heartbeat"
+	"dot/v2/apiserver/locate"
+	"dot/v2/apiserver/metadata"
+	"dot/v2/apiserver/objectstream"
+	"dot/v2/utils"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+func get(w http.ResponseWriter, r *http.Request) {
+	_, name, code := objectName(r)
+	if code != http.StatusOK {
+		w.WriteHeader(code)
+		return
+	}
+	meta, code := requestedMetadata(r, name)
+	if code != http.StatusOK {
+		w.WriteHeader(code)
+		return
+	}
+	var dk []byte
+	if meta.CustomerKey != nil {
+		if dk, code = dataKey(r, meta); code != http.StatusOK {
+			w.WriteHeader(code)
+			return
+		}
+	}
+	tag := etag(meta)
+	if code := checkRead(r, meta); code != http.StatusOK {
+		if servesEncoded(r, meta, r.Header.Get("Range")) {
+			tag = encodedETag(meta, meta.Compression)
+		}
+		w.Header().Set("ETag", tag)
+		w.WriteHeader(code)
+		return
+	}
+
+	// 支持 Range 断点续传, If-Range 与 ETag 不一致时忽略 Range 返回整个对象;
+	// 压缩保存的对象需要从头解压, 见 buckets.Bucket.Compression
+	size := meta.ObjectSize()
+	offset, length := int64(0), size
+	var err error
+	rangeHeader := r.Header.Get("Range")
+	if ifRange := r.Header.Get("If-Range"); ifRange != "" && (strings.HasPrefix(ifRange, "W/") || !matchIdentity(ifRange, meta)) {
+		rangeHeader = ""
+	}
+	if rangeHeader != "" {
+		offset, length, err = utils.ParseRange(rangeHeader, size)
+		if err != nil {
+			log.Println(err)
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+	}
+
+	// 客户端接受对象保存时使用的压缩算法时直接返回压缩的数据, 不在接口服务解压
+	if meta.Compression != "" {
+		w.Header().Set("Vary", "Accept-Encoding")
+	}
+	var stream io.ReadCloser
+	encoding := ""
+	if servesEncoded(r, meta, rangeHeader) {
+		if s := getEncodedStream(meta); s != nil {
+			stream, encoding = s, s.Encoding
+		}
+	}
+	if dk != nil {
+		stream, err = decryptedStream(meta, dk, offset)
+	} else if stream == nil && rangeHeader != "" {
+		stream, err = getStream(meta, offset, length)
+	} else if stream == nil {
+		stream, err = getStream(meta, 0, -1)
+	}
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	defer stream.Close()
+	setHeaders(w, meta)
+	setCustomerKeyHeaders(w, meta)
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("ETag", tag)
+	if encoding != "" {
+		// 压缩的表示与原始数据的字节不同, 使用不同的强 ETag
+		w.Header().Set("ETag", encodedETag(meta, encoding))
+		w.Header().Set("Content-Encoding", encoding)
+		io.Copy(w, stream)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	if rangeHeader != "" {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, size))
+		w.WriteHeader(http.StatusPartialContent)
+	}
+	io.Copy(w, io.LimitReader(stream, length))
+}
+
+// NewReader 读取对象某个版本的全部数据, 以客户密钥加密的版本读到的是加密后的数据
+func NewReader(meta metadata.Metadata) (io.ReadCloser, error) {
+	return getStream(meta, 0, -1)
+}
+
+// servesEncoded 是否直接返回对象压缩保存的原始数据: 没有 Range, 客户端接受保存时的压缩算法,
+// 且冗余策略可以读取原始数据(见 getEncodedStream); HEAD 与条件请求据此选择返回的 ETag
+func servesEncoded(r *http.Request, meta metadata.Metadata, rangeHeader string) bool {
+	if rangeHeader != "" || meta.Compression == "" || !utils.AcceptsEncoding(r.Header, meta.Compression) {
+		return false
+	}
+	scheme, err := objectstream.ParseScheme(meta.Scheme)
+	return err == nil && scheme.Type != objectstream.SchemeRS
+}
+
+// getEncodedStream 读取单副本或多副本对象保存的原始数据, 压缩保存时不解压;
+// 纠删码对象没有完整的文件, 读取失败时同样返回 nil, 由调用方改为解压读取
+func getEncodedStream(meta metadata.Metadata) *objectstream.GetStream {
+	scheme, err := objectstream.ParseScheme(meta.Scheme)
+	if err != nil {
+		return nil
+	}
+	object := url.PathEscape(meta.Hash)
+	var stream *objectstream.GetStream
+	if scheme.Type == objectstream.SchemeReplica {
+		stream, err = objectstream.NewReplicaEncodedGetStream(locate.LocateReplicas(object, scheme.Replicas), object)
+	} else if scheme.Type == objectstream.SchemeSingle {
+		stream, err = objectstream.NewEncodedGetStream(locate.Locate(object), object)
+	}
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	return stream
+}
+
+// getStream 读取对象从 offset 开始的 length 个字节, length < 0 表示读到末尾
+func getStream(meta metadata.Metadata, offset, length int64) (io.ReadCloser, error) {
+	scheme, err := objectstream.ParseScheme(meta.Scheme)
+	if err != nil {
+		return nil, err
+	}
+	object := url.PathEscape(meta.Hash)
+	if scheme.Type == objectstream.SchemeRS {
+		info := locate.LocateShards(object, scheme.Servers())
+		if len(info) < scheme.DataShards {
+			return nil, fmt.Errorf("object %s locate fail, found %d shards", object, len(info))
+		}
+		// 为丢失的分片选择新的数据服务, 读取的同时进行修复
+		var dataServers []string
+		if len(info) != scheme.Servers() {
+			exclude := make([]string, 0, len(info))
+			for _, server := range info {
+				exclude = append(exclude, server)
+			}
+			dataServers = heartbeat.ChooseRandomDataServers(scheme.Servers()-len(info), exclude)
+		}
+		return objectstream.NewRSGetStream(scheme, info, dataServers, object, meta.Size, offset)
+	}
+	if scheme.Type == objectstream.SchemeReplica {
+		servers := locate.LocateReplicas(object, scheme.Replicas)
+		if len(servers) == 0 {
+			return nil, fmt.Errorf("object %s locate fail", object)
+		}
+		return objectstream.NewReplicaGetStream(servers, object, offset, length)
+	}
+	server := locate.Locate(object)
+	if server == "" {
+		return nil, fmt.Errorf("object %s locate fail", object)
+	}
+	return objectstream.NewGetStream(server, object, offset, length)
+}
@@ -0,0 +1,137 @@
+package objects
+
+import (
+	"dot/v2/apiserver/metadata"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// MetaPrefix 用户自定义元数据的请求头前缀, 如 X-Meta-Author
+const MetaPrefix = "X-Meta-"
+
+// storedHeaders 随对象版本保存, 读取对象时原样返回的标准请求头
+var storedHeaders = []string{"Content-Type", "Content-Encoding", "Cache-Control"}
+
+// Headers 从请求头中取出随对象保存的部分: X-Meta-* 与 Content-Type 等, 键为规范化的请求头名,
+// 没有时返回 nil
+func Headers(h http.Header) map[string]string {
+	var headers map[string]string
+	add := func(key, value string) {
+		if headers == nil {
+			headers = make(map[string]string)
+		}
+		headers[key] = value
+	}
+	for _, key := range storedHeaders {
+		if v := h.Get(key); v != "" {
+			add(key, v)
+		}
+	}
+	for key, values := range h {
+		key = http.CanonicalHeaderKey(key)
+		if strings.HasPrefix(key, MetaPrefix) && len(key) > len(MetaPrefix) {
+			add(key, strings.Join(values, ","))
+		}
+	}
+	return headers
+}
+
+// setHeaders 在响应中返回对象版本保存的请求头
+func setHeaders(w http.ResponseWriter, meta metadata.Metadata) {
+	for key, value := range meta.Headers {
+		w.Header().Set(key, value)
+	}
+}
+
+// requestedMetadata 查找请求的对象版本, 由查询参数 version 指定, 未指定时为最新版本;
+// 对象不存在或该版本为删除标记时返回 404
+func requestedMetadata(r *http.Request, name string) (metadata.Metadata, int) {
+	version := 0
+	if v := r.URL.Query().Get("version"); v != "" {
+		var err error
+		version, err = strconv.Atoi(v)
+		if err != nil || version <= 0 {
+			log.Printf("invalid version %q", v)
+			return metadata.Metadata{}, http.StatusBadRequest
+		}
+	}
+	meta, err := metadata.GetMetadata(name, version)
+	if err != nil {
+		log.Println(err)
+		return metadata.Metadata{}, http.StatusNotFound
+	}
+	if meta.Version == 0 || meta.IsDeleteMarker() {
+		return metadata.Metadata{}, http.StatusNotFound
+	}
+	return meta, http.StatusOK
+}
+
+// head 返回对象的大小, ETag 与保存的请求头, 不读取数据, 支持与 GET 相同的条件请求头;
+// 以客户密钥加密的版本与 GET 一样需要给出密钥
+func head(w http.ResponseWriter, r *http.Request) {
+	_, name, code := objectName(r)
+	if code != http.StatusOK {
+		w.WriteHeader(code)
+		return
+	}
+	meta, code := requestedMetadata(r, name)
+	if code != http.StatusOK {
+		w.WriteHeader(code)
+		return
+	}
+	if meta.CustomerKey != nil {
+		if _, code = dataKey(r, meta); code != http.StatusOK {
+			w.WriteHeader(code)
+			return
+		}
+	}
+	// 与 GET 相同协商返回的表示, 返回压缩数据时 GET 不给出长度, HEAD 同样不给出
+	encoded := servesEncoded(r, meta, r.Header.Get("Range"))
+	if encoded {
+		w.Header().Set("ETag", encodedETag(meta, meta.Compression))
+	} else {
+		w.Header().Set("ETag", etag(meta))
+	}
+	if code := checkRead(r, meta); code != http.StatusOK {
+		w.WriteHeader(code)
+		return
+	}
+	setHeaders(w, meta)
+	setCustomerKeyHeaders(w, meta)
+	if meta.Compression != "" {
+		w.Header().Set("Vary", "Accept-Encoding")
+	}
+	w.Header().Set("Accept-Ranges", "bytes")
+	if encoded {
+		w.Header().Set("Content-Encoding", meta.Compression)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(meta.ObjectSize(), 10))
+}
+
+// updateHeaders 修改对象版本保存的请求头而不重新上传数据: POST /objects/<bucket>/<key>?metadata,
+// 请求中的 X-Meta-* 与 Content-Type 等请求头整体替换原有的值, 查询参数 version 指定修改的版本, 默认为最新版本
+func updateHeaders(w http.ResponseWriter, r *http.Request) {
+	_, name, code := objectName(r)
+	if code != http.StatusOK {
+		w.WriteHeader(code)
+		return
+	}
+	versionMutex.Lock()
+	defer versionMutex.Unlock()
+	meta, code := requestedMetadata(r, name)
+	if code != http.StatusOK {
+		w.WriteHeader(code)
+		return
+	}
+	meta.Headers = Headers(r.Header)
+	err := metadata.PutMetadata(meta)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,51 @@
+package objects
+
+import (
+	"dot/v2/apiserver/buckets"
+	"dot/v2/apiserver/metadata"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// list 列出对象: GET /objects?prefix=&delimiter=&marker=&limit=, 对象名为 <bucket>/<key>
+//
+// 返回 JSON 格式的 metadata.ListResult, 列表被截断时以 nextMarker 作为 marker 继续列出
+func list(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit := 0
+	if l := q.Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit <= 0 {
+			log.Printf("invalid limit %q", l)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	result, err := metadata.List(q.Get("prefix"), q.Get("delimiter"), q.Get("marker"), limit)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	// 不列出系统内部存储桶中的对象
+	objects := result.Objects[:0]
+	for _, m := range result.Objects {
+		if !buckets.Reserved(m.Name) {
+			objects = append(objects, m)
+		}
+	}
+	result.Objects = objects
+	prefixes := result.CommonPrefixes[:0]
+	for _, p := range result.CommonPrefixes {
+		if !buckets.Reserved(p) {
+			prefixes = append(prefixes, p)
+		}
+	}
+	result.CommonPrefixes = prefixes
+	b, _ := json.Marshal(result)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
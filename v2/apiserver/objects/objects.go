@@ -1,12 +1,8 @@
 package objects
 
 import (
-	"dot/v2/apiserver/heartbeat"
-	"dot/v2/apiserver/locate"
-	"dot/v2/apiserver/objectstream"
-	"fmt"
-	"io"
-	"log"
+	"context"
+	"dot/v2/apiserver/buckets"
 	"net/http"
 	"strings"
 )
@@ -15,58 +11,62 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 	method := r.Method
 	if method == http.MethodPut {
 		put(w, r)
+		return
+	}
+	if method == http.MethodGet && (r.URL.Path == "/objects" || r.URL.Path == "/objects/") {
+		list(w, r)
+		return
 	}
 	if method == http.MethodGet {
 		get(w, r)
+		return
+	}
+	if method == http.MethodHead {
+		head(w, r)
+		return
+	}
+	if method == http.MethodDelete {
+		del(w, r)
+		return
+	}
+	if method == http.MethodPost && r.URL.Query().Has("metadata") {
+		updateHeaders(w, r)
+		return
+	}
+	if method == http.MethodPost {
+		post(w, r)
+		return
 	}
 	w.WriteHeader(http.StatusMethodNotAllowed)
 }
- 
-func put(w http.ResponseWriter, r *http.Request) {
-	object := strings.Split(r.URL.EscapedPath(), "/")[2]
-	c, err := storeObject(r.Body, object)
-	if err != nil {
-		log.Println(err)
+
+// objectName 解析 /objects/<bucket>/<key...>, 返回对象所在的存储桶与对象名 <bucket>/<key>,
+// key 中可以包含 /; 路径中缺少存储桶或 key 时返回 400, 存储桶不存在时返回 404
+//
+// 只有一段的 /objects/<name> 为存储桶功能之前写入的对象, 与当时一致以转义的路径为对象名, 属于 buckets.Legacy;
+// 系统内部使用的存储桶只能由 WithInternal 的请求访问, 否则视为不存在
+func objectName(r *http.Request) (buckets.Bucket, string, int) {
+	if escaped := strings.TrimPrefix(r.URL.EscapedPath(), "/objects/"); escaped != "" && !strings.Contains(escaped, "/") {
+		return buckets.Legacy, escaped, http.StatusOK
 	}
-	w.WriteHeader(c)
-}
- 
-func storeObject(r io.Reader, object string) (int, error) {
-	stream, err := putStream(object)
-	if err != nil {
-		return http.StatusServiceUnavailable, err
+	name := strings.TrimPrefix(r.URL.Path, "/objects/")
+	bucket, key, _ := strings.Cut(name, "/")
+	if bucket == "" || key == "" {
+		return buckets.Bucket{}, "", http.StatusBadRequest
 	}
-	io.Copy(stream, r)
-	err = stream.Close()
-	if err != nil {
-		return http.StatusInternalServerError, err
+	if buckets.Reserved(bucket) && r.Context().Value(internalKey{}) == nil {
+		return buckets.Bucket{}, "", http.StatusNotFound
 	}
-	return http.StatusOK, nil
-}
- 
-func putStream(object string) (*objectstream.PutStream, error) {
-	server := heartbeat.ChooseRandomDataServer()
-	if server == "" {
-		return nil, fmt.Errorf("cannot find any dataserver")
+	b, ok := buckets.Get(bucket)
+	if !ok {
+		return buckets.Bucket{}, "", http.StatusNotFound
 	}
-	return objectstream.NewPutStream(server, object), nil
+	return b, name, http.StatusOK
 }
- 
-func get(w http.ResponseWriter, r *http.Request) {
-	object := strings.Split(r.URL.EscapedPath(), "/")[2]
-	stream, err := getStream(object)
-	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusNotFound)
-		return
-	}
-	io.Copy(w, stream)
+
+type internalKey struct{}
+
+// WithInternal 返回可以访问系统内部存储桶的 ctx, 用于 S3 接口读写分段上传的分段
+func WithInternal(ctx context.Context) context.Context {
+	return context.WithValue(ctx, internalKey{}, true)
 }
- 
-func getStream(object string) (io.Reader, error) {
-	server := locate.Locate(object)
-	if server == "" {
-		return nil, fmt.Errorf("object %s locate fail", object)
-	}
-	return objectstream.NewGetStream(server, object)
-}
\ No newline at end of file
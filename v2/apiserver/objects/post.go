@@ -0,0 +1,69 @@
+package objects
+
+import (
+	"dot/v2/apiserver/heartbeat"
+	"dot/v2/apiserver/objectstream"
+	"dot/v2/utils"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// post 创建断点续传上传任务: POST /objects/<bucket>/<key>, 请求头 Digest 为对象散列值, Size 为对象大小,
+// 返回的 Location 为上传地址 /uploads/<token>, X-Meta-* 与 Content-Type 等请求头在上传完成后随对象保存
+func post(w http.ResponseWriter, r *http.Request) {
+	b, name, code := objectName(r)
+	if code != http.StatusOK {
+		w.WriteHeader(code)
+		return
+	}
+	// 以客户密钥加密时要在接口服务上暂存整个对象, 断点续传的数据暂存在数据服务上, 因此不支持
+	if r.Header.Get(CustomerKeyHeader) != "" {
+		log.Println("resumable upload does not support customer keys")
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+	hash := utils.GetHashFromHeader(r.Header)
+	if hash == "" {
+		log.Println("missing object hash in digest header")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	size, err := strconv.ParseInt(r.Header.Get("Size"), 0, 64)
+	if err != nil || size < 0 {
+		log.Printf("invalid object size %q", r.Header.Get("Size"))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if b.MaxSize != 0 && size > b.MaxSize {
+		log.Printf("object size %d exceeds bucket %s limit %d", size, b.Name, b.MaxSize)
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+	scheme, err := storageScheme(b)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// 上传的数据先暂存在一个数据服务的临时对象中, 全部上传并校验后再按冗余策略写入
+	server := heartbeat.ChooseRandomDataServer()
+	if server == "" {
+		log.Println("cannot find any dataserver")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	// 单副本策略直接确认暂存的临时对象, 因此暂存时同样要求压缩
+	c := compression(b, r.Header)
+	stream, err := objectstream.NewTempPutStream(server, url.PathEscape(hash), size, c)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	u := upload{Name: name, Size: size, Hash: hash, Scheme: scheme.String(), Server: stream.Server, Uuid: stream.Uuid, Compression: c, Headers: Headers(r.Header)}
+	w.Header().Set("Location", "/uploads/"+u.token())
+	w.WriteHeader(http.StatusCreated)
+}
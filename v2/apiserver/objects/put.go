@@ -0,0 +1,301 @@
+package objects
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"dot/v2/apiserver/buckets"
+	"dot/v2/apiserver/heartbeat"
+	"dot/v2/apiserver/locate"
+	"dot/v2/apiserver/metadata"
+	"dot/v2/apiserver/objectstream"
+	"dot/v2/utils"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+func put(w http.ResponseWriter, r *http.Request) {
+	b, name, code := objectName(r)
+	if code != http.StatusOK {
+		w.WriteHeader(code)
+		return
+	}
+	hash := utils.GetHashFromHeader(r.Header)
+	if hash == "" {
+		log.Println("missing object hash in digest header")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	size := r.ContentLength
+	if size < 0 {
+		log.Println("missing object size in content-length header")
+		w.WriteHeader(http.StatusLengthRequired)
+		return
+	}
+	if b.MaxSize != 0 && size > b.MaxSize {
+		log.Printf("object size %d exceeds bucket %s limit %d", size, b.Name, b.MaxSize)
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+	key, keyMD5, code := customerKey(r.Header)
+	if code != http.StatusOK {
+		w.WriteHeader(code)
+		return
+	}
+	scheme, err := storageScheme(b)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	// 条件写入先检查一次, 避免上传注定失败的数据; 写入元数据时加锁再检查一次
+	if conditional(r) {
+		latest, err := metadata.SearchLatestVersion(name)
+		if err == nil {
+			err = checkWrite(r, latest)
+		}
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(writeStatus(err))
+			return
+		}
+	}
+	meta := metadata.Metadata{Name: name, Size: size, Hash: hash, Scheme: scheme.String(), Compression: compression(b, r.Header), Headers: Headers(r.Header)}
+	// 以客户密钥加密的数据每次都不同, 不参与去重, 加密后的数据暂存在本地再以其散列值写入
+	body := io.Reader(r.Body)
+	if key != nil {
+		f, c, err := encryptObject(r.Body, key, keyMD5, &meta)
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(c)
+			return
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+		body = f
+	}
+	replaced, c, err := storeVersion(w, r, b, &meta, scheme, body)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(c)
+		return
+	}
+	if replaced != "" {
+		removeData(replaced)
+	}
+	setCustomerKeyHeaders(w, meta)
+	w.Header().Set("ETag", etag(meta))
+	if sum, err := hex.DecodeString(meta.ETag); err == nil && len(sum) == md5.Size {
+		w.Header().Set("Content-MD5", base64.StdEncoding.EncodeToString(sum))
+	}
+}
+
+type etagKey struct{}
+
+// WithETag 返回带有 S3 ETag 的 ctx, 以它发出的 PUT 请求写入的版本记录 tag 而不是数据的 MD5, 用于分段上传拼接的对象
+func WithETag(ctx context.Context, tag string) context.Context {
+	return context.WithValue(ctx, etagKey{}, tag)
+}
+
+// storeVersion 写入数据或复用已有的相同数据, 再写入对象的新版本, 返回被覆盖的版本的散列值, 由调用方回收;
+// 整个过程持有 meta.Hash 的锁, 期间数据不会被并发的删除回收
+func storeVersion(w http.ResponseWriter, r *http.Request, b buckets.Bucket, meta *metadata.Metadata, scheme objectstream.Scheme, body io.Reader) (string, int, error) {
+	unlock := lockHash(meta.Hash)
+	defer unlock()
+	if meta.CustomerKey != nil {
+		c, err := storeObject(body, meta.Hash, meta.Size, scheme, "")
+		if err != nil {
+			return "", c, err
+		}
+	} else if existing, ok := existingData(meta.Hash, meta.Size, scheme); ok {
+		// 相同内容的数据已经存在时只新增版本, 不读取请求体, 带 Expect: 100-continue 的客户端不会发送数据
+		log.Printf("object %s deduplicated, data %s already stored as %s", meta.Name, meta.Hash, existing.Scheme)
+		meta.Scheme, meta.Compression = existing.Scheme, existing.Compression
+		if !strings.Contains(existing.ETag, "-") {
+			meta.ETag = existing.ETag
+		}
+		w.Header().Set("X-Deduplicated", "true")
+	} else {
+		h := md5.New()
+		c, err := storeObject(io.TeeReader(body, h), meta.Hash, meta.Size, scheme, meta.Compression)
+		if err != nil {
+			return "", c, err
+		}
+		meta.ETag = hex.EncodeToString(h.Sum(nil))
+	}
+	if tag, ok := r.Context().Value(etagKey{}).(string); ok {
+		meta.ETag = tag
+	}
+	replaced, err := addVersion(b, *meta, r)
+	if err != nil {
+		// 检查期间对象被其它请求修改, 刚写入的数据可能已无引用
+		if errors.Is(err, errPreconditionFailed) {
+			removeDataLocked(meta.Hash)
+		}
+		return "", writeStatus(err), err
+	}
+	return replaced, http.StatusOK, nil
+}
+
+// writeStatus 写入元数据失败时返回的状态码
+func writeStatus(err error) int {
+	if errors.Is(err, errPreconditionFailed) {
+		return http.StatusPreconditionFailed
+	}
+	if errors.Is(err, buckets.ErrNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+ 
+// storageScheme 新写入对象使用的冗余策略, 存储桶未指定时由 STORAGE_SCHEME 配置
+func storageScheme(b buckets.Bucket) (objectstream.Scheme, error) {
+	if b.Scheme != "" {
+		return objectstream.ParseScheme(b.Scheme)
+	}
+	return objectstream.ParseScheme(os.Getenv("STORAGE_SCHEME"))
+}
+
+// versionMutex 串行化同一接口服务上的条件检查与元数据写入, 只保护元数据, 持有时不访问数据服务
+var versionMutex sync.Mutex
+
+// compression 新写入对象使用的压缩算法, 由存储桶的策略决定;
+// 客户端上传的已经是编码过的数据(带 Content-Encoding)时不再压缩
+func compression(b buckets.Bucket, h http.Header) string {
+	if h.Get("Content-Encoding") != "" {
+		return ""
+	}
+	return b.Compression
+}
+
+// addVersion 写入对象的新版本; 存储桶开启版本控制时新增一个版本, 否则覆盖最新版本,
+// 返回被覆盖的版本的散列值, 调用方在释放数据的锁之后以 removeData 回收, 没有被覆盖的数据时返回空
+//
+// r 不为 nil 时在写入前以当前最新版本检查其 If-Match/If-None-Match, 不满足时返回 errPreconditionFailed;
+// 写入期间存储桶不能被删除, 写入前已被删除时返回 buckets.ErrNotFound
+func addVersion(b buckets.Bucket, m metadata.Metadata, r *http.Request) (string, error) {
+	release, err := buckets.Hold(m.Name)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	versionMutex.Lock()
+	defer versionMutex.Unlock()
+	var latest metadata.Metadata
+	if !b.Versioning || (r != nil && conditional(r)) {
+		latest, err = metadata.SearchLatestVersion(m.Name)
+		if err != nil {
+			return "", err
+		}
+	}
+	if r != nil {
+		if err := checkWrite(r, latest); err != nil {
+			return "", err
+		}
+	}
+	if b.Versioning {
+		_, err := metadata.AddVersion(m)
+		return "", err
+	}
+	m.Version = latest.Version
+	if m.Version == 0 {
+		m.Version = 1
+	}
+	err = metadata.PutMetadata(m)
+	if err != nil {
+		return "", err
+	}
+	if latest.Version != 0 && !latest.IsDeleteMarker() && latest.Hash != m.Hash {
+		return latest.Hash, nil
+	}
+	return "", nil
+}
+
+// existingData 查找内容为 hash 的已有数据: 元数据中有引用该散列值且大小相同的版本, 其冗余策略不低于 scheme,
+// 且数据服务上仍能定位到足够的副本或分片, 使现存的数据同样能容忍 scheme 允许丢失的数据服务数;
+// 返回引用它的任意一个版本, 新版本沿用其冗余策略. 调用方需持有 hash 的锁
+func existingData(hash string, size int64, scheme objectstream.Scheme) (metadata.Metadata, bool) {
+	meta, err := metadata.SearchHash(hash)
+	if err != nil {
+		log.Println(err)
+		return metadata.Metadata{}, false
+	}
+	if meta.Hash != hash || meta.Size != size {
+		return metadata.Metadata{}, false
+	}
+	existing, err := objectstream.ParseScheme(meta.Scheme)
+	if err != nil || existing.Tolerance() < scheme.Tolerance() {
+		return metadata.Metadata{}, false
+	}
+	// 可以再丢失的数据服务数: 纠删码为定位到的分片数减去数据分片数, 完整对象为定位到的副本数减一
+	object := url.PathEscape(hash)
+	spare := len(locate.LocateReplicas(object, existing.Servers())) - 1
+	if existing.Type == objectstream.SchemeRS {
+		spare = len(locate.LocateShards(object, existing.Servers())) - existing.DataShards
+	}
+	if spare < 0 || spare < scheme.Tolerance() {
+		return metadata.Metadata{}, false
+	}
+	return meta, true
+}
+
+// storeObject 将数据流写入数据服务的临时对象, 大小与散列值都校验通过后才确认上传,
+// compression 不为空时数据服务以该算法压缩保存
+func storeObject(r io.Reader, hash string, size int64, scheme objectstream.Scheme, compression string) (int, error) {
+	stream, err := putStream(url.PathEscape(hash), size, scheme, compression)
+	if err != nil {
+		return http.StatusServiceUnavailable, err
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(stream, h), r)
+	if err != nil {
+		stream.Commit(false)
+		return http.StatusInternalServerError, err
+	}
+	if n != size {
+		stream.Commit(false)
+		return http.StatusBadRequest, fmt.Errorf("object size mismatch, actual=%d, expected=%d", n, size)
+	}
+	d := base64.StdEncoding.EncodeToString(h.Sum(nil))
+	if d != hash {
+		stream.Commit(false)
+		return http.StatusBadRequest, fmt.Errorf("object hash mismatch, calculated=%s, requested=%s", d, hash)
+	}
+	err = stream.Commit(true)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+ 
+func putStream(object string, size int64, scheme objectstream.Scheme, compression string) (objectstream.Committer, error) {
+	if scheme.Type == objectstream.SchemeRS {
+		servers := heartbeat.ChooseRandomDataServers(scheme.Servers(), nil)
+		if len(servers) != scheme.Servers() {
+			return nil, fmt.Errorf("cannot find enough dataserver, need %d, got %d", scheme.Servers(), len(servers))
+		}
+		return objectstream.NewRSPutStream(scheme, servers, object, size, compression)
+	}
+	if scheme.Type == objectstream.SchemeReplica {
+		// 可用数据服务少于副本数时, 只要不少于写入仲裁数仍然可以写入
+		servers := heartbeat.ChooseRandomDataServers(scheme.Replicas, nil)
+		if len(servers) < scheme.WriteQuorum {
+			return nil, fmt.Errorf("cannot find enough dataserver, need %d, got %d", scheme.WriteQuorum, len(servers))
+		}
+		return objectstream.NewReplicaPutStream(servers, object, size, scheme.WriteQuorum, compression)
+	}
+	server := heartbeat.ChooseRandomDataServer()
+	if server == "" {
+		return nil, fmt.Errorf("cannot find any dataserver")
+	}
+	return objectstream.NewTempPutStream(server, object, size, compression)
+}
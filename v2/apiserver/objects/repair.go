@@ -0,0 +1,59 @@
+package objects
+
+import (
+	"dot/v2/apiserver/heartbeat"
+	"dot/v2/apiserver/locate"
+	"dot/v2/apiserver/metadata"
+	"dot/v2/apiserver/objectstream"
+	"fmt"
+	"io"
+	"net/url"
+)
+
+// Repair 根据对象的冗余策略重建丢失或损坏的副本、分片
+func Repair(meta metadata.Metadata) error {
+	scheme, err := objectstream.ParseScheme(meta.Scheme)
+	if err != nil {
+		return err
+	}
+	object := url.PathEscape(meta.Hash)
+	switch scheme.Type {
+	case objectstream.SchemeRS:
+		// 完整读取一遍对象, RSGetStream 会把丢失的分片写入新的数据服务
+		stream, err := getStream(meta, 0, -1)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(io.Discard, stream)
+		stream.Close()
+		return err
+	case objectstream.SchemeReplica:
+		servers := locate.LocateReplicas(object, scheme.Replicas)
+		if len(servers) == 0 {
+			return fmt.Errorf("object %s has no replica left", object)
+		}
+		if len(servers) >= scheme.Replicas {
+			return nil
+		}
+		targets := heartbeat.ChooseRandomDataServers(scheme.Replicas-len(servers), servers)
+		if len(targets) == 0 {
+			return fmt.Errorf("cannot find dataserver to repair object %s", object)
+		}
+		src, err := objectstream.NewReplicaGetStream(servers, object, 0, -1)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		dst, err := objectstream.NewReplicaPutStream(targets, object, meta.Size, len(targets), meta.Compression)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(dst, src)
+		if err != nil {
+			dst.Commit(false)
+			return err
+		}
+		return dst.Commit(true)
+	}
+	return fmt.Errorf("object %s uses scheme %s and cannot be repaired", object, scheme)
+}
@@ -0,0 +1,46 @@
+package objects
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// spoolDir 暂存上传数据的本地目录, 由 SPOOL_DIR 配置, 默认为 STORAGE_ROOT/spool;
+// 目录中只有正在处理的请求的暂存文件, 接口服务启动时由 SweepSpool 清空, 不要与其它用途共用
+func spoolDir() string {
+	if dir := os.Getenv("SPOOL_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(os.Getenv("STORAGE_ROOT"), "spool")
+}
+
+// CreateSpool 在暂存目录中创建临时文件, 用于 SSE-C 加密后的数据与 S3 接口未签名的请求体, 调用方负责关闭并删除
+func CreateSpool(pattern string) (*os.File, error) {
+	dir := spoolDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return os.CreateTemp(dir, pattern)
+}
+
+// SweepSpool 删除上次运行遗留的暂存文件, 进程异常退出时来不及删除的暂存文件会一直占用磁盘,
+// 需在处理请求之前调用
+func SweepSpool() {
+	dir := spoolDir()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println(err)
+		}
+		return
+	}
+	for _, e := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, e.Name())); err != nil {
+			log.Println(err)
+		}
+	}
+	if len(entries) > 0 {
+		log.Printf("Removed %d stale spool files from %s", len(entries), dir)
+	}
+}
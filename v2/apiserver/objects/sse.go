@@ -0,0 +1,146 @@
+package objects
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"dot/v2/apiserver/metadata"
+	"dot/v2/encryption"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+)
+
+// 客户提供密钥的服务端加密(SSE-C): 上传时在请求头中给出 base64 编码的 32 字节 AES-256 密钥,
+// 可同时给出密钥的 base64 MD5 以检查传输错误; 读取该版本时必须给出同一个密钥, 服务端不保存客户密钥
+const (
+	CustomerKeyHeader    = "X-Encryption-Customer-Key"
+	CustomerKeyMD5Header = "X-Encryption-Customer-Key-MD5"
+)
+
+// customerKey 取出请求头中的客户密钥及其 MD5, 没有给出时返回 nil; 密钥格式不正确或与 MD5 不符时返回 400
+func customerKey(h http.Header) ([]byte, string, int) {
+	value := h.Get(CustomerKeyHeader)
+	if value == "" {
+		return nil, "", http.StatusOK
+	}
+	key, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(key) != encryption.KeySize {
+		log.Printf("invalid customer key, must be %d bytes base64 encoded", encryption.KeySize)
+		return nil, "", http.StatusBadRequest
+	}
+	sum := md5.Sum(key)
+	keyMD5 := base64.StdEncoding.EncodeToString(sum[:])
+	if v := h.Get(CustomerKeyMD5Header); v != "" && v != keyMD5 {
+		log.Println("customer key MD5 mismatch")
+		return nil, "", http.StatusBadRequest
+	}
+	return key, keyMD5, http.StatusOK
+}
+
+// dataKey 用请求中的客户密钥解开 SSE-C 版本的数据密钥, 没有给出密钥时返回 400, 密钥不正确时返回 403
+func dataKey(r *http.Request, meta metadata.Metadata) ([]byte, int) {
+	key, keyMD5, code := customerKey(r.Header)
+	if code != http.StatusOK {
+		return nil, code
+	}
+	if key == nil {
+		log.Printf("object %s version %d is encrypted with a customer key", meta.Name, meta.Version)
+		return nil, http.StatusBadRequest
+	}
+	if keyMD5 != meta.CustomerKey.KeyMD5 {
+		log.Printf("customer key mismatch for object %s version %d", meta.Name, meta.Version)
+		return nil, http.StatusForbidden
+	}
+	dk, err := encryption.UnwrapKey(key, meta.CustomerKey.WrappedKey)
+	if err != nil {
+		log.Println(err)
+		return nil, http.StatusForbidden
+	}
+	return dk, http.StatusOK
+}
+
+// encryptObject 以随机的数据密钥加密请求体并暂存到本地临时文件, 同时校验明文的大小与散列值,
+// 数据密钥由客户密钥包装后记入 meta.CustomerKey, meta 的 Size 与 Hash 改为加密后的数据;
+// 调用方负责关闭并删除返回的文件
+func encryptObject(r io.Reader, key []byte, keyMD5 string, meta *metadata.Metadata) (*os.File, int, error) {
+	dk := make([]byte, encryption.KeySize)
+	if _, err := rand.Read(dk); err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	wrapped, err := encryption.WrapKey(key, dk)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	f, err := CreateSpool("sse-c-")
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	code, err := func() (int, error) {
+		h, ch := sha256.New(), sha256.New()
+		enc, err := encryption.NewWriter(io.MultiWriter(f, ch), dk)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		n, err := io.Copy(io.MultiWriter(enc, h), io.LimitReader(r, meta.Size+1))
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		if n != meta.Size {
+			return http.StatusBadRequest, fmt.Errorf("object size mismatch, actual=%d, expected=%d", n, meta.Size)
+		}
+		d := base64.StdEncoding.EncodeToString(h.Sum(nil))
+		if d != meta.Hash {
+			return http.StatusBadRequest, fmt.Errorf("object hash mismatch, calculated=%s, requested=%s", d, meta.Hash)
+		}
+		if err := enc.Close(); err != nil {
+			return http.StatusInternalServerError, err
+		}
+		_, err = f.Seek(0, io.SeekStart)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		meta.Size = encryption.EncryptedSize(n)
+		meta.Hash = base64.StdEncoding.EncodeToString(ch.Sum(nil))
+		return http.StatusOK, nil
+	}()
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, code, err
+	}
+	meta.Compression = ""
+	meta.CustomerKey = &metadata.CustomerKey{KeyMD5: keyMD5, WrappedKey: wrapped}
+	return f, http.StatusOK, nil
+}
+
+// decryptedStream 读取 SSE-C 版本从明文 offset 开始的数据, 只读取 offset 所在的块及之后的加密数据
+func decryptedStream(meta metadata.Metadata, dk []byte, offset int64) (io.ReadCloser, error) {
+	chunk, encryptedOffset := encryption.ChunkOffset(offset)
+	stream, err := getStream(meta, encryptedOffset, -1)
+	if err != nil {
+		return nil, err
+	}
+	r, err := encryption.NewStreamReader(stream, dk, chunk)
+	if err == nil {
+		_, err = io.CopyN(io.Discard, r, offset-chunk*encryption.ChunkSize)
+	}
+	if err != nil {
+		stream.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{r, stream}, nil
+}
+
+// setCustomerKeyHeaders 在响应中返回 SSE-C 版本使用的客户密钥 MD5
+func setCustomerKeyHeaders(w http.ResponseWriter, meta metadata.Metadata) {
+	if meta.CustomerKey != nil {
+		w.Header().Set(CustomerKeyMD5Header, meta.CustomerKey.KeyMD5)
+	}
+}
@@ -0,0 +1,202 @@
+package objects
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"dot/v2/apiserver/buckets"
+	"dot/v2/apiserver/metadata"
+	"dot/v2/apiserver/objectstream"
+	"dot/v2/utils"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// upload 断点续传上传任务, 编码并签名后作为上传地址中的 token
+type upload struct {
+	Name   string
+	Size   int64
+	Hash   string
+	Scheme string
+	Server string // 暂存数据的数据服务
+	Uuid   string // 暂存数据的临时对象
+	// Compression 数据服务保存对象时使用的压缩算法
+	Compression string `json:",omitempty"`
+	// Headers 创建上传任务时随对象保存的请求头
+	Headers map[string]string `json:",omitempty"`
+}
+
+var (
+	tokenSecret     []byte
+	tokenSecretOnce sync.Once
+)
+
+// uploadTokenSecret 返回签名上传 token 的密钥, 为 UPLOAD_TOKEN_SECRET;
+// 未设置时使用进程启动后随机生成的密钥, 此时 token 在重启后失效, 也不能由其它接口服务处理
+func uploadTokenSecret() []byte {
+	tokenSecretOnce.Do(func() {
+		tokenSecret = []byte(os.Getenv("UPLOAD_TOKEN_SECRET"))
+		if len(tokenSecret) == 0 {
+			log.Println("UPLOAD_TOKEN_SECRET not set, upload tokens are only valid on this server until restart")
+			tokenSecret = make([]byte, 32)
+			rand.Read(tokenSecret)
+		}
+	})
+	return tokenSecret
+}
+
+func signToken(payload string) []byte {
+	mac := hmac.New(sha256.New, uploadTokenSecret())
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// token 格式为 <base64 JSON>.<base64 HMAC-SHA256>, 签名防止客户端篡改暂存数据的数据服务,
+// 对象名, 冗余策略与大小等创建上传任务时已按存储桶策略检查过的内容
+func (u upload) token() string {
+	b, _ := json.Marshal(u)
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(signToken(payload))
+}
+
+func parseToken(token string) (upload, error) {
+	var u upload
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return u, fmt.Errorf("invalid upload token %q: missing signature", token)
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, signToken(payload)) {
+		return u, fmt.Errorf("invalid upload token %q: signature mismatch", token)
+	}
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return u, fmt.Errorf("invalid upload token %q: %w", token, err)
+	}
+	err = json.Unmarshal(b, &u)
+	if err != nil {
+		return u, fmt.Errorf("invalid upload token %q: %w", token, err)
+	}
+	return u, nil
+}
+
+// UploadsHandler 断点续传接口
+//
+//	HEAD /uploads/<token>  响应头 Upload-Offset 为已接收的字节数, Upload-Length 为对象大小
+//	PUT  /uploads/<token>  请求头 Range: bytes=<offset>- 从 offset 处继续上传, offset 须等于已接收的字节数
+func UploadsHandler(w http.ResponseWriter, r *http.Request) {
+	u, err := parseToken(strings.Split(r.URL.EscapedPath(), "/")[2])
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	stream := &objectstream.TempPutStream{Server: u.Server, Uuid: u.Uuid}
+	current, err := stream.Size()
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Upload-Length", strconv.FormatInt(u.Size, 10))
+
+	method := r.Method
+	if method == http.MethodHead {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(current, 10))
+		return
+	}
+	if method != http.MethodPut {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	offset := utils.GetOffsetFromHeader(r.Header)
+	if offset != current {
+		log.Printf("upload offset mismatch, requested=%d, received=%d", offset, current)
+		w.Header().Set("Upload-Offset", strconv.FormatInt(current, 10))
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	_, err = io.Copy(stream, io.LimitReader(r.Body, u.Size-current))
+	if err == nil {
+		err = stream.Flush()
+	}
+	if err == nil {
+		current, err = stream.Size()
+	}
+	if err != nil {
+		// 客户端中断上传时已写入的数据保留, 可以从 Upload-Offset 处继续
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(current, 10))
+	if n, _ := r.Body.Read(make([]byte, 1)); n != 0 {
+		log.Printf("upload %s exceeds declared size %d", u.Name, u.Size)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if current < u.Size {
+		return
+	}
+
+	b, ok := buckets.Of(u.Name)
+	if !ok {
+		log.Printf("bucket of upload %s not found", u.Name)
+		stream.Commit(false)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	// 与 put 相同, 写入数据与元数据期间持有数据的锁, 被覆盖的数据在解锁后回收
+	unlock := lockHash(u.Hash)
+	c, err := commitUpload(u, stream)
+	if err != nil {
+		unlock()
+		log.Println(err)
+		w.WriteHeader(c)
+		return
+	}
+	replaced, err := addVersion(b, metadata.Metadata{Name: u.Name, Size: u.Size, Hash: u.Hash, Scheme: u.Scheme, Compression: u.Compression, Headers: u.Headers}, nil)
+	unlock()
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if replaced != "" {
+		removeData(replaced)
+	}
+}
+
+// commitUpload 所有数据接收完毕后校验并写入正式对象
+//
+// 单副本策略直接确认暂存的临时对象, 由数据服务校验散列值; 其它策略读出暂存数据,
+// 经 storeObject 校验大小与散列值后按冗余策略写入, 再删除暂存数据
+func commitUpload(u upload, stream *objectstream.TempPutStream) (int, error) {
+	scheme, err := objectstream.ParseScheme(u.Scheme)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if scheme.Type == objectstream.SchemeSingle {
+		err = stream.Commit(true)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+		return http.StatusOK, nil
+	}
+	defer stream.Commit(false)
+	r, err := objectstream.NewTempGetStream(u.Server, u.Uuid)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	defer r.Close()
+	return storeObject(r, u.Hash, u.Size, scheme, u.Compression)
+}
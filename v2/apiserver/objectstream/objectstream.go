@@ -1,62 +1,127 @@
 package objectstream
 
 import (
+	"dot/v2/utils"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 )
  
-type PutStream struct {
-	writer *io.PipeWriter
-	c      chan error
-}
- 
-func NewPutStream(server, object string) *PutStream {
-	reader, writer := io.Pipe()
-	c := make(chan error)
-	go func() {
-		request, _ := http.NewRequest("PUT", "http://"+server+"/objects/"+object, reader)
-		client := http.Client{}
-		resp, err := client.Do(request)
-		if err != nil && resp.StatusCode != http.StatusOK {
-			err = fmt.Errorf("dataserver return http code %d", resp.StatusCode)
-		}
-		c <- err
-	}()
-	return &PutStream{writer, c}
-}
- 
-func (w *PutStream) Write(p []byte) (int, error) {
-	return w.writer.Write(p)
-}
- 
-func (w *PutStream) Close() error {
-	w.writer.Close()
-	return <-w.c
+// Committer 需要确认或放弃的写入流, 如 TempPutStream, RSPutStream
+type Committer interface {
+	io.Writer
+	Commit(good bool) error
 }
  
+// GetStream 从数据服务读取对象, 数据服务压缩保存的对象默认在读取时解压
 type GetStream struct {
-	reader io.Reader
+	reader io.ReadCloser
+	// Encoding Read 返回的数据的编码, 为空表示原始数据, 只有 NewEncodedGetStream 可能不为空
+	Encoding string
 }
  
-func newGetStream(url string) (*GetStream, error) {
-	resp, err := http.Get(url)
+// newGetStream decode 为 true 时解压数据服务返回的压缩数据, 并从中截取请求的区间
+func newGetStream(url string, offset, length int64, decode bool) (*GetStream, error) {
+	request, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
-	if resp.StatusCode != http.StatusOK {
+	if offset != 0 || length >= 0 {
+		request.Header.Set("Range", rangeHeader(offset, length))
+	}
+	// 显式声明 Accept-Encoding, 避免 http.Transport 自动解压 gzip 并去掉 Content-Encoding
+	request.Header.Set("Accept-Encoding", utils.CompressionGzip+", "+utils.CompressionZstd)
+	client := http.Client{}
+	resp, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
 		return nil, fmt.Errorf("dataServer return http code %d", resp.StatusCode)
 	}
-	return &GetStream{resp.Body}, nil
+	encoding := resp.Header.Get("Content-Encoding")
+	if encoding == "" || !decode {
+		return &GetStream{resp.Body, encoding}, nil
+	}
+	// 压缩保存的对象不支持 Range, 数据服务返回整个文件, 解压后跳过 offset 之前的数据
+	d, err := utils.NewDecompressReader(resp.Body, encoding)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	var r io.Reader = d
+	if resp.StatusCode == http.StatusOK && offset != 0 {
+		_, err = io.CopyN(io.Discard, d, offset)
+		if err != nil {
+			d.Close()
+			resp.Body.Close()
+			return nil, err
+		}
+	}
+	if length >= 0 {
+		r = io.LimitReader(d, length)
+	}
+	return &GetStream{reader: &decodeReader{r, d, resp.Body}}, nil
 }
  
-func NewGetStream(server, object string) (*GetStream, error) {
+// NewGetStream 从数据服务读取对象从 offset 开始的 length 个字节, length < 0 表示读到末尾
+func NewGetStream(server, object string, offset, length int64) (*GetStream, error) {
+	if server == "" || object == "" {
+		return nil, fmt.Errorf("invalid server %s object %s", server, object)
+	}
+	return newGetStream("http://"+server+"/objects/"+object, offset, length, true)
+}
+
+// NewEncodedGetStream 读取数据服务保存的整个对象, 压缩保存时不解压, Encoding 为其压缩算法
+func NewEncodedGetStream(server, object string) (*GetStream, error) {
 	if server == "" || object == "" {
 		return nil, fmt.Errorf("invalid server %s object %s", server, object)
 	}
-	return newGetStream("http://" + server + "/objects" + object)
+	return newGetStream("http://"+server+"/objects/"+object, 0, -1, false)
+}
+ 
+func rangeHeader(offset, length int64) string {
+	if length < 0 {
+		return fmt.Sprintf("bytes=%d-", offset)
+	}
+	return fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
 }
  
 func (r *GetStream) Read(p []byte) (int, error) {
 	return r.reader.Read(p)
-}
\ No newline at end of file
+}
+
+func (r *GetStream) Close() error {
+	return r.reader.Close()
+}
+
+// decodeReader 读取解压后的数据, 关闭时同时关闭解压器与响应体
+type decodeReader struct {
+	io.Reader
+	decoder io.Closer
+	body    io.Closer
+}
+
+func (r *decodeReader) Close() error {
+	r.decoder.Close()
+	return r.body.Close()
+}
+
+// DeleteObject 通知所有数据服务删除对象, 未保存该对象的数据服务返回 404, 忽略即可
+func DeleteObject(servers []string, object string) {
+	for _, server := range servers {
+		request, _ := http.NewRequest("DELETE", "http://"+server+"/objects/"+object, nil)
+		client := http.Client{}
+		resp, err := client.Do(request)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+			log.Printf("dataserver %s return http code %d when deleting %s", server, resp.StatusCode, object)
+		}
+	}
+}
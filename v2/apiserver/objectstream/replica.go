@@ -0,0 +1,116 @@
+package objectstream
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// ReplicaPutStream 将同一个对象同时写入多个数据服务, 至少 quorum 个副本确认成功才算写入成功
+type ReplicaPutStream struct {
+	writers []*TempPutStream
+	quorum  int
+}
+
+// NewReplicaPutStream 在每个数据服务上创建一个副本, 个别数据服务失败时只要剩余副本数不少于 quorum 即可继续
+func NewReplicaPutStream(dataServers []string, object string, size int64, quorum int, compression string) (*ReplicaPutStream, error) {
+	writers := make([]*TempPutStream, 0, len(dataServers))
+	for _, server := range dataServers {
+		w, err := NewTempPutStream(server, object, size, compression)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		writers = append(writers, w)
+	}
+	if len(writers) < quorum {
+		for _, w := range writers {
+			w.Commit(false)
+		}
+		return nil, fmt.Errorf("only %d replicas created, write quorum is %d", len(writers), quorum)
+	}
+	return &ReplicaPutStream{writers, quorum}, nil
+}
+
+// Write 把数据同时写入所有副本, 写入失败或超过 WriteTimeout 仍未写完的副本被放弃,
+// 剩余副本不足 quorum 时返回错误
+func (s *ReplicaPutStream) Write(p []byte) (int, error) {
+	timeout := WriteTimeout()
+	errs := make([]error, len(s.writers))
+	var wg sync.WaitGroup
+	for i, w := range s.writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = w.writeTimeout(p, timeout)
+		}()
+	}
+	wg.Wait()
+	writers := s.writers[:0]
+	for i, w := range s.writers {
+		if errs[i] != nil {
+			log.Printf("replica %s write fail: %v", w.Server, errs[i])
+			// 无响应的数据服务上删除临时对象同样可能阻塞, 不等待其完成
+			go w.Commit(false)
+			continue
+		}
+		writers = append(writers, w)
+	}
+	s.writers = writers
+	if len(s.writers) < s.quorum {
+		return 0, fmt.Errorf("only %d replicas alive, write quorum is %d", len(s.writers), s.quorum)
+	}
+	return len(p), nil
+}
+
+// WriteTimeout 向单个副本写入一块数据的超时时间, 由 REPLICA_WRITE_TIMEOUT 配置, 默认 30s
+func WriteTimeout() time.Duration {
+	timeout, err := time.ParseDuration(os.Getenv("REPLICA_WRITE_TIMEOUT"))
+	if err != nil || timeout <= 0 {
+		return 30 * time.Second
+	}
+	return timeout
+}
+
+// Commit 确认所有副本, 确认成功的副本数不足 quorum 时返回错误
+func (s *ReplicaPutStream) Commit(good bool) error {
+	acked := 0
+	for _, w := range s.writers {
+		if err := w.Commit(good); err != nil {
+			log.Printf("replica %s commit fail: %v", w.Server, err)
+			continue
+		}
+		acked++
+	}
+	if good && acked < s.quorum {
+		return fmt.Errorf("only %d replicas acknowledged, write quorum is %d", acked, s.quorum)
+	}
+	return nil
+}
+
+// NewReplicaGetStream 依次尝试从各个副本读取对象, 直到成功为止
+func NewReplicaGetStream(servers []string, object string, offset, length int64) (*GetStream, error) {
+	return replicaGetStream(servers, object, func(server string) (*GetStream, error) {
+		return NewGetStream(server, object, offset, length)
+	})
+}
+
+// NewReplicaEncodedGetStream 依次尝试从各个副本读取保存的原始数据, 见 NewEncodedGetStream
+func NewReplicaEncodedGetStream(servers []string, object string) (*GetStream, error) {
+	return replicaGetStream(servers, object, func(server string) (*GetStream, error) {
+		return NewEncodedGetStream(server, object)
+	})
+}
+
+func replicaGetStream(servers []string, object string, open func(server string) (*GetStream, error)) (*GetStream, error) {
+	for _, server := range servers {
+		stream, err := open(server)
+		if err == nil {
+			return stream, nil
+		}
+		log.Printf("replica %s unavailable: %v", server, err)
+	}
+	return nil, fmt.Errorf("object %s has no available replica in %v", object, servers)
+}
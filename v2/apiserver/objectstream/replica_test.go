@@ -0,0 +1,85 @@
+package objectstream
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeTempServer 模拟数据服务的临时对象接口, stall 为 true 时 PATCH 请求不读取请求体也不返回
+func fakeTempServer(t *testing.T, stall bool) (string, *bytes.Buffer) {
+	var mutex sync.Mutex
+	data := new(bytes.Buffer)
+	release := make(chan struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			w.Write([]byte("uuid"))
+		case http.MethodPatch:
+			if stall {
+				<-release
+				return
+			}
+			mutex.Lock()
+			defer mutex.Unlock()
+			io.Copy(data, r.Body)
+		}
+	}))
+	t.Cleanup(func() {
+		close(release)
+		s.Close()
+	})
+	return strings.TrimPrefix(s.URL, "http://"), data
+}
+
+// TestReplicaWriteTimeout 无响应的副本超时后被放弃, 其余副本满足写入仲裁时继续写入
+func TestReplicaWriteTimeout(t *testing.T) {
+	t.Setenv("REPLICA_WRITE_TIMEOUT", "200ms")
+	a, dataA := fakeTempServer(t, false)
+	b, dataB := fakeTempServer(t, false)
+	stalled, _ := fakeTempServer(t, true)
+	// 写入的数据超过 TCP 缓冲区, 无响应的副本才会阻塞
+	const writes = 32
+	chunk := bytes.Repeat([]byte("x"), 1<<20)
+	s, err := NewReplicaPutStream([]string{a, stalled, b}, "object", writes*int64(len(chunk)), 2, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	for i := 0; i < writes; i++ {
+		if _, err := s.Write(chunk); err != nil {
+			t.Fatalf("write %d: %v", i, err)
+		}
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("writes took %v with one stalled replica", d)
+	}
+	if len(s.writers) != 2 {
+		t.Fatalf("%d replicas alive, want 2", len(s.writers))
+	}
+	if err := s.Commit(true); err != nil {
+		t.Fatal(err)
+	}
+	if dataA.Len() != writes*len(chunk) || dataB.Len() != writes*len(chunk) {
+		t.Errorf("replicas received %d and %d bytes, want %d", dataA.Len(), dataB.Len(), writes*len(chunk))
+	}
+
+	// 剩余副本不足写入仲裁时返回错误
+	s, err = NewReplicaPutStream([]string{a, stalled}, "object", writes*int64(len(chunk)), 2, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < writes && err == nil; i++ {
+		_, err = s.Write(chunk)
+	}
+	if err == nil {
+		t.Error("write succeeded below the write quorum")
+	}
+	s.Commit(false)
+}
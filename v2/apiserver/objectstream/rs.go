@@ -0,0 +1,308 @@
+package objectstream
+
+import (
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/klauspost/reedsolomon"
+)
+
+// BlockPerShard 每次编解码时每个分片的数据块大小
+const BlockPerShard = 8000
+
+// shardObject 分片在数据服务上的对象名: <hash>.<id>
+func shardObject(object string, id int) string {
+	return fmt.Sprintf("%s.%d", object, id)
+}
+
+// shardSize 对象拆分后每个分片的大小
+func shardSize(scheme Scheme, size int64) int64 {
+	data := int64(scheme.DataShards)
+	return (size + data - 1) / data
+}
+
+type encoder struct {
+	writers   []*TempPutStream
+	enc       reedsolomon.Encoder
+	cache     []byte
+	blockSize int
+}
+
+func newEncoder(writers []*TempPutStream, scheme Scheme) (*encoder, error) {
+	enc, err := reedsolomon.New(scheme.DataShards, scheme.ParityShards)
+	if err != nil {
+		return nil, err
+	}
+	blockSize := scheme.DataShards * BlockPerShard
+	return &encoder{writers, enc, make([]byte, 0, blockSize), blockSize}, nil
+}
+
+func (e *encoder) Write(p []byte) (int, error) {
+	current := 0
+	for current < len(p) {
+		next := e.blockSize - len(e.cache)
+		if next > len(p)-current {
+			next = len(p) - current
+		}
+		e.cache = append(e.cache, p[current:current+next]...)
+		if len(e.cache) == e.blockSize {
+			if err := e.Flush(); err != nil {
+				return current, err
+			}
+		}
+		current += next
+	}
+	return len(p), nil
+}
+
+// Flush 将缓存的数据编码后写入各个分片
+func (e *encoder) Flush() error {
+	if len(e.cache) == 0 {
+		return nil
+	}
+	shards, err := e.enc.Split(e.cache)
+	if err != nil {
+		return err
+	}
+	err = e.enc.Encode(shards)
+	if err != nil {
+		return err
+	}
+	for i := range shards {
+		if _, err := e.writers[i].Write(shards[i]); err != nil {
+			return err
+		}
+	}
+	e.cache = e.cache[:0]
+	return nil
+}
+
+// RSPutStream 将对象以纠删码分片的形式写入多个数据服务
+type RSPutStream struct {
+	*encoder
+}
+
+// NewRSPutStream dataServers 的数量须等于分片总数, 第 i 个分片写入 dataServers[i], 各分片分别以 compression 压缩保存
+func NewRSPutStream(scheme Scheme, dataServers []string, object string, size int64, compression string) (*RSPutStream, error) {
+	if len(dataServers) != scheme.Servers() {
+		return nil, fmt.Errorf("dataServers number mismatch, need %d, got %d", scheme.Servers(), len(dataServers))
+	}
+	perShard := shardSize(scheme, size)
+	writers := make([]*TempPutStream, 0, len(dataServers))
+	for i, server := range dataServers {
+		w, err := NewTempPutStream(server, shardObject(object, i), perShard, compression)
+		if err != nil {
+			for _, w := range writers {
+				w.Commit(false)
+			}
+			return nil, err
+		}
+		writers = append(writers, w)
+	}
+	enc, err := newEncoder(writers, scheme)
+	if err != nil {
+		for _, w := range writers {
+			w.Commit(false)
+		}
+		return nil, err
+	}
+	return &RSPutStream{enc}, nil
+}
+
+// Commit 所有分片都确认成功时返回 nil
+func (s *RSPutStream) Commit(good bool) error {
+	var err error
+	if good {
+		err = s.Flush()
+		good = err == nil
+	}
+	for _, w := range s.writers {
+		if e := w.Commit(good); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+type decoder struct {
+	readers   []io.ReadCloser
+	writers   []*TempPutStream
+	enc       reedsolomon.Encoder
+	scheme    Scheme
+	size      int64
+	cache     []byte
+	cacheSize int
+	total     int64
+	skip      int // 第一个数据块中需要跳过的字节数
+}
+
+func newDecoder(readers []io.ReadCloser, writers []*TempPutStream, scheme Scheme, size int64) (*decoder, error) {
+	enc, err := reedsolomon.New(scheme.DataShards, scheme.ParityShards)
+	if err != nil {
+		return nil, err
+	}
+	return &decoder{readers: readers, writers: writers, enc: enc, scheme: scheme, size: size}, nil
+}
+
+func (d *decoder) Read(p []byte) (int, error) {
+	for d.cacheSize == 0 {
+		if err := d.getData(); err != nil {
+			return 0, err
+		}
+		if d.skip != 0 {
+			n := d.skip
+			if n > d.cacheSize {
+				n = d.cacheSize
+			}
+			d.cache = d.cache[n:]
+			d.cacheSize -= n
+			d.skip -= n
+		}
+	}
+	n := copy(p, d.cache[:d.cacheSize])
+	d.cache = d.cache[n:]
+	d.cacheSize -= n
+	return n, nil
+}
+
+// getData 从各分片读取一个数据块, 还原丢失的分片并写入修复流
+func (d *decoder) getData() error {
+	if d.total == d.size {
+		return io.EOF
+	}
+	blockLen := int64(BlockPerShard)
+	if rest := shardSize(d.scheme, d.size-d.total); rest < blockLen {
+		blockLen = rest
+	}
+	shards := make([][]byte, d.scheme.Servers())
+	repair := false
+	for i := range shards {
+		if d.readers[i] == nil {
+			repair = repair || d.writers[i] != nil
+			continue
+		}
+		shards[i] = make([]byte, blockLen)
+		_, err := io.ReadFull(d.readers[i], shards[i])
+		if err != nil {
+			// 分片读取失败后不再使用, 中途损坏的分片无法就地修复
+			log.Printf("shard %d read fail: %v", i, err)
+			shards[i] = nil
+			d.readers[i].Close()
+			d.readers[i] = nil
+		}
+	}
+	var err error
+	if repair {
+		err = d.enc.Reconstruct(shards)
+	} else {
+		err = d.enc.ReconstructData(shards)
+	}
+	if err != nil {
+		return err
+	}
+	for i, w := range d.writers {
+		if w == nil {
+			continue
+		}
+		if _, err := w.Write(shards[i]); err != nil {
+			log.Printf("shard %d repair fail: %v", i, err)
+			w.Commit(false)
+			d.writers[i] = nil
+		}
+	}
+	d.cache = d.cache[:0]
+	for i := 0; i < d.scheme.DataShards; i++ {
+		shard := shards[i]
+		if rest := d.size - d.total; int64(len(shard)) > rest {
+			shard = shard[:rest]
+		}
+		d.cache = append(d.cache, shard...)
+		d.total += int64(len(shard))
+	}
+	d.cacheSize = len(d.cache)
+	return nil
+}
+
+// RSGetStream 从纠删码分片中读取对象, 读取过程中顺带修复丢失的分片
+type RSGetStream struct {
+	*decoder
+}
+
+// NewRSGetStream 从 offset 开始读取对象, locateInfo 为分片 id 到数据服务的映射
+//
+// 各分片只从 offset 所在的数据块开始读取; 从头读取时丢失的分片依次写入 dataServers 进行修复
+func NewRSGetStream(scheme Scheme, locateInfo map[int]string, dataServers []string, object string, size, offset int64) (*RSGetStream, error) {
+	all := scheme.Servers()
+	blockSize := int64(scheme.DataShards * BlockPerShard)
+	block := offset / blockSize
+	if offset != 0 {
+		dataServers = nil
+	}
+	readers := make([]io.ReadCloser, all)
+	writers := make([]*TempPutStream, all)
+	found := 0
+	for i := 0; i < all; i++ {
+		server := locateInfo[i]
+		if server != "" {
+			reader, err := NewGetStream(server, shardObject(object, i), block*BlockPerShard, -1)
+			if err == nil {
+				readers[i] = reader
+				found++
+				continue
+			}
+			log.Println(err)
+		}
+		if len(dataServers) == 0 {
+			continue
+		}
+		// 修复的分片不压缩, 数据服务按文件名区分压缩与未压缩的分片
+		writer, err := NewTempPutStream(dataServers[0], shardObject(object, i), shardSize(scheme, size), "")
+		dataServers = dataServers[1:]
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		writers[i] = writer
+	}
+	if found < scheme.DataShards {
+		abortStreams(readers, writers)
+		return nil, fmt.Errorf("object %s has only %d shards, need %d", object, found, scheme.DataShards)
+	}
+	dec, err := newDecoder(readers, writers, scheme, size)
+	if err != nil {
+		abortStreams(readers, writers)
+		return nil, err
+	}
+	dec.total = block * blockSize
+	dec.skip = int(offset - dec.total)
+	return &RSGetStream{dec}, nil
+}
+
+// Close 对象完整读取后确认修复的分片, 否则放弃修复
+func (s *RSGetStream) Close() error {
+	for _, r := range s.readers {
+		if r != nil {
+			r.Close()
+		}
+	}
+	for _, w := range s.writers {
+		if w != nil {
+			w.Commit(s.total == s.size)
+		}
+	}
+	return nil
+}
+
+func abortStreams(readers []io.ReadCloser, writers []*TempPutStream) {
+	for _, r := range readers {
+		if r != nil {
+			r.Close()
+		}
+	}
+	for _, w := range writers {
+		if w != nil {
+			w.Commit(false)
+		}
+	}
+}
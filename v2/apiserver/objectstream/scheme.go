@@ -0,0 +1,90 @@
+package objectstream
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	SchemeSingle  = "single"
+	SchemeRS      = "rs"
+	SchemeReplica = "replica"
+)
+
+// Scheme 对象的数据冗余策略
+//
+//	single      整个对象保存在一个数据服务上
+//	rs-<D>-<P>  Reed-Solomon 纠删码, 对象拆分为 D 个数据分片和 P 个校验分片, 分别保存在不同的数据服务上
+//	replica-<N>-<W>  完整对象保存 N 个副本, 至少 W 个副本写入成功才算成功, 省略 W 时为多数派
+type Scheme struct {
+	Type         string
+	DataShards   int
+	ParityShards int
+	Replicas     int
+	WriteQuorum  int
+}
+
+// ParseScheme 解析冗余策略, 空字符串视为 single, rs 视为 rs-4-2, replica 视为 replica-3-2
+func ParseScheme(s string) (Scheme, error) {
+	if s == "" || s == SchemeSingle {
+		return Scheme{Type: SchemeSingle}, nil
+	}
+	if s == SchemeRS {
+		return Scheme{Type: SchemeRS, DataShards: 4, ParityShards: 2}, nil
+	}
+	if s == SchemeReplica {
+		return Scheme{Type: SchemeReplica, Replicas: 3, WriteQuorum: 2}, nil
+	}
+	parts := strings.Split(s, "-")
+	nums := make([]int, 0, len(parts)-1)
+	for _, part := range parts[1:] {
+		n, err := strconv.Atoi(part)
+		if err != nil || n <= 0 {
+			return Scheme{}, fmt.Errorf("invalid storage scheme %q", s)
+		}
+		nums = append(nums, n)
+	}
+	if parts[0] == SchemeRS && len(nums) == 2 && nums[0]+nums[1] <= 256 {
+		return Scheme{Type: SchemeRS, DataShards: nums[0], ParityShards: nums[1]}, nil
+	}
+	if parts[0] == SchemeReplica && len(nums) == 1 {
+		return Scheme{Type: SchemeReplica, Replicas: nums[0], WriteQuorum: nums[0]/2 + 1}, nil
+	}
+	if parts[0] == SchemeReplica && len(nums) == 2 && nums[1] <= nums[0] {
+		return Scheme{Type: SchemeReplica, Replicas: nums[0], WriteQuorum: nums[1]}, nil
+	}
+	return Scheme{}, fmt.Errorf("invalid storage scheme %q", s)
+}
+
+func (s Scheme) String() string {
+	switch s.Type {
+	case SchemeRS:
+		return fmt.Sprintf("%s-%d-%d", SchemeRS, s.DataShards, s.ParityShards)
+	case SchemeReplica:
+		return fmt.Sprintf("%s-%d-%d", SchemeReplica, s.Replicas, s.WriteQuorum)
+	}
+	return s.Type
+}
+
+// Tolerance 对象完整写入后可以丢失而不影响读取的数据服务数量
+func (s Scheme) Tolerance() int {
+	switch s.Type {
+	case SchemeRS:
+		return s.ParityShards
+	case SchemeReplica:
+		return s.Replicas - 1
+	}
+	return 0
+}
+
+// Servers 写入一个对象所需的数据服务数量
+func (s Scheme) Servers() int {
+	switch s.Type {
+	case SchemeRS:
+		return s.DataShards + s.ParityShards
+	case SchemeReplica:
+		return s.Replicas
+	}
+	return 1
+}
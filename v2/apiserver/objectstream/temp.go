@@ -0,0 +1,153 @@
+package objectstream
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TempPutStream 以临时对象的方式写入数据服务, 调用 Commit 之后数据才会成为正式对象
+type TempPutStream struct {
+	Server string
+	Uuid   string
+	mutex  sync.Mutex // 保护 reader 与 writer, abort 在另一个 goroutine 中调用
+	reader *io.PipeReader
+	writer *io.PipeWriter
+	c      chan error
+}
+
+// NewTempPutStream 在数据服务上创建临时对象, compression 不为空时数据服务确认上传后以该算法压缩保存
+func NewTempPutStream(server, object string, size int64, compression string) (*TempPutStream, error) {
+	request, err := http.NewRequest("POST", "http://"+server+"/temp/"+object, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Size", strconv.FormatInt(size, 10))
+	if compression != "" {
+		request.Header.Set("Compression", compression)
+	}
+	client := http.Client{}
+	resp, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("dataserver return http code %d", resp.StatusCode)
+	}
+	uuid, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &TempPutStream{Server: server, Uuid: strings.TrimSpace(string(uuid))}, nil
+}
+
+// Write 通过一个持续的 PATCH 请求把数据追加到临时对象
+func (w *TempPutStream) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	if w.writer == nil {
+		reader, writer := io.Pipe()
+		w.reader, w.writer, w.c = reader, writer, make(chan error, 1)
+		go func() {
+			request, _ := http.NewRequest("PATCH", "http://"+w.Server+"/temp/"+w.Uuid, reader)
+			client := http.Client{}
+			resp, err := client.Do(request)
+			if err == nil {
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusOK {
+					err = fmt.Errorf("dataserver return http code %d", resp.StatusCode)
+				}
+			}
+			reader.CloseWithError(err)
+			w.c <- err
+		}()
+	}
+	writer := w.writer
+	w.mutex.Unlock()
+	return writer.Write(p)
+}
+
+// writeTimeout 与 Write 相同, 但超过 timeout 仍未写完时中断 PATCH 请求并返回错误,
+// 数据服务无响应时不会一直阻塞; 中断后只能以 Commit(false) 放弃
+func (w *TempPutStream) writeTimeout(p []byte, timeout time.Duration) error {
+	timer := time.AfterFunc(timeout, func() {
+		w.abort(fmt.Errorf("write to %s timed out after %v", w.Server, timeout))
+	})
+	_, err := w.Write(p)
+	if !timer.Stop() {
+		return fmt.Errorf("write to %s timed out after %v", w.Server, timeout)
+	}
+	return err
+}
+
+// abort 中断当前的 PATCH 请求, 阻塞中的 Write 返回 err
+func (w *TempPutStream) abort(err error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.reader != nil {
+		w.reader.CloseWithError(err)
+	}
+}
+
+// Flush 结束当前的 PATCH 请求, 之后的 Write 会发起新的 PATCH 请求继续追加
+func (w *TempPutStream) Flush() error {
+	w.mutex.Lock()
+	writer, c := w.writer, w.c
+	w.reader, w.writer = nil, nil
+	w.mutex.Unlock()
+	if writer == nil {
+		return nil
+	}
+	writer.Close()
+	return <-c
+}
+
+// Size 查询临时对象已写入的字节数
+func (w *TempPutStream) Size() (int64, error) {
+	request, _ := http.NewRequest("HEAD", "http://"+w.Server+"/temp/"+w.Uuid, nil)
+	client := http.Client{}
+	resp, err := client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("dataserver return http code %d", resp.StatusCode)
+	}
+	return resp.ContentLength, nil
+}
+
+// Commit good 为 true 时确认上传, 否则放弃并删除临时对象
+func (w *TempPutStream) Commit(good bool) error {
+	if err := w.Flush(); err != nil {
+		w.request("DELETE")
+		return err
+	}
+	if good {
+		return w.request("PUT")
+	}
+	return w.request("DELETE")
+}
+
+func (w *TempPutStream) request(method string) error {
+	request, _ := http.NewRequest(method, "http://"+w.Server+"/temp/"+w.Uuid, nil)
+	client := http.Client{}
+	resp, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("dataserver return http code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// NewTempGetStream 读取数据服务上临时对象已写入的数据
+func NewTempGetStream(server, uuid string) (*GetStream, error) {
+	return newGetStream("http://"+server+"/temp/"+uuid, 0, -1, true)
+}
@@ -0,0 +1,209 @@
+package rebuild
+
+import (
+	"dot/v2/apiserver/buckets"
+	"dot/v2/apiserver/heartbeat"
+	"dot/v2/apiserver/metadata"
+	"dot/v2/apiserver/objectstream"
+	"dot/v2/types"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+)
+
+// LostFound 没有元数据的完整对象恢复到这个存储桶中, 对象名为 lost-found/<hash>
+const LostFound = "lost-found"
+
+// Report 重建结果
+type Report struct {
+	Objects   int      `json:"objects"`   // 数据服务上保存的对象数, 同一对象的副本与分片只计一次
+	Recovered []string `json:"recovered"` // 恢复到 lost-found 存储桶的对象名
+	Orphans   []string `json:"orphans"`   // 没有元数据且无法恢复的对象散列值, 如纠删码分片
+	Missing   []string `json:"missing"`   // 数据服务上找不到足够数据的对象名
+}
+
+// copies 一个对象在各数据服务上的文件
+type copies struct {
+	size        int64        // 对象大小, 只有压缩保存的完整对象时为 -1
+	compression string       // 完整对象压缩保存时使用的压缩算法
+	whole       []string     // 保存完整对象的数据服务
+	shards      map[int]bool // 分片id
+}
+
+// Handler 根据数据服务的对象列表重建元数据索引: POST /rebuild, 返回 JSON 格式的 Report
+func Handler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	report, err := Rebuild()
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	b, _ := json.Marshal(report)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// Rebuild 汇总所有数据服务的对象列表并与元数据核对
+//
+// 从未被任何版本引用的完整对象以 single 或 replica-N 策略恢复到 lost-found 存储桶中;
+// 纠删码分片无法得知原始大小与分片数, 只记录为孤立对象; 元数据引用的数据缺失时记录为 missing
+func Rebuild() (Report, error) {
+	report := Report{Recovered: []string{}, Orphans: []string{}, Missing: []string{}}
+	all := make(map[string]*copies)
+	for _, server := range heartbeat.GetDataServers() {
+		err := collect(server, all)
+		if err != nil {
+			return report, fmt.Errorf("list dataserver %s fail: %w", server, err)
+		}
+	}
+	report.Objects = len(all)
+
+	hashes := make([]string, 0, len(all))
+	for hash := range all {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+	for _, escaped := range hashes {
+		hash, err := url.PathUnescape(escaped)
+		if err != nil {
+			continue
+		}
+		meta, err := metadata.SearchHash(hash)
+		if err != nil {
+			return report, err
+		}
+		if meta.Version != 0 {
+			continue
+		}
+		c := all[escaped]
+		if len(c.whole) == 0 {
+			report.Orphans = append(report.Orphans, hash)
+			continue
+		}
+		name, err := restore(hash, c)
+		if err != nil {
+			return report, err
+		}
+		report.Recovered = append(report.Recovered, name)
+	}
+
+	marker := ""
+	for {
+		metas, err := metadata.SearchLatestVersions("", marker, metadata.MaxListSize)
+		if err != nil {
+			return report, err
+		}
+		for _, m := range metas {
+			if !available(m, all[url.PathEscape(m.Hash)]) {
+				report.Missing = append(report.Missing, m.Name)
+			}
+			marker = m.Name
+		}
+		if len(metas) < metadata.MaxListSize {
+			break
+		}
+	}
+	log.Printf("Rebuild finished, %d objects, %d recovered, %d orphans, %d missing",
+		report.Objects, len(report.Recovered), len(report.Orphans), len(report.Missing))
+	return report, nil
+}
+
+// collect 分页读取数据服务的对象列表
+func collect(server string, all map[string]*copies) error {
+	marker := ""
+	for {
+		resp, err := http.Get("http://" + server + "/list?marker=" + url.QueryEscape(marker))
+		if err != nil {
+			return err
+		}
+		var list types.ObjectList
+		if resp.StatusCode != http.StatusOK {
+			err = fmt.Errorf("dataserver return http code %d", resp.StatusCode)
+		} else {
+			err = json.NewDecoder(resp.Body).Decode(&list)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		for _, e := range list.Objects {
+			c := all[e.Hash]
+			if c == nil {
+				c = &copies{size: -1, shards: make(map[int]bool)}
+				all[e.Hash] = c
+			}
+			if e.Id == types.WholeObject && e.Compression != "" {
+				c.compression = e.Compression
+				c.whole = append(c.whole, server)
+			} else if e.Id == types.WholeObject {
+				c.size = e.Size
+				c.whole = append(c.whole, server)
+			} else {
+				c.shards[e.Id] = true
+			}
+		}
+		if list.NextMarker == "" {
+			return nil
+		}
+		marker = list.NextMarker
+	}
+}
+
+// restore 为没有元数据的完整对象新增一个版本
+func restore(hash string, c *copies) (string, error) {
+	_, err := buckets.Create(buckets.Bucket{Name: LostFound, Versioning: true})
+	if err != nil && !errors.Is(err, buckets.ErrExists) {
+		return "", err
+	}
+	scheme := objectstream.Scheme{Type: objectstream.SchemeSingle}
+	if n := len(c.whole); n > 1 {
+		scheme = objectstream.Scheme{Type: objectstream.SchemeReplica, Replicas: n, WriteQuorum: n/2 + 1}
+	}
+	// 压缩保存的文件大小不是对象大小, 需要解压读取一遍
+	if c.size < 0 {
+		c.size, err = decompressedSize(c.whole[0], url.PathEscape(hash))
+		if err != nil {
+			return "", err
+		}
+	}
+	m, err := metadata.AddVersion(metadata.Metadata{Name: LostFound + "/" + hash, Size: c.size, Hash: hash, Scheme: scheme.String(), Compression: c.compression})
+	if err != nil {
+		return "", err
+	}
+	log.Printf("Recovered object %s from %v", m.Name, c.whole)
+	return m.Name, nil
+}
+
+// decompressedSize 读取数据服务上的对象, 返回解压后的大小
+func decompressedSize(server, object string) (int64, error) {
+	stream, err := objectstream.NewGetStream(server, object, 0, -1)
+	if err != nil {
+		return 0, err
+	}
+	defer stream.Close()
+	return io.Copy(io.Discard, stream)
+}
+
+// available 判断数据服务上是否保存了足够读取对象的数据
+func available(m metadata.Metadata, c *copies) bool {
+	if c == nil {
+		return false
+	}
+	scheme, err := objectstream.ParseScheme(m.Scheme)
+	if err != nil {
+		return false
+	}
+	if scheme.Type == objectstream.SchemeRS {
+		return len(c.shards) >= scheme.DataShards
+	}
+	return len(c.whole) != 0
+}
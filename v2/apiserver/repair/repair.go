@@ -0,0 +1,55 @@
+package repair
+
+import (
+	"context"
+	"dot/v2/apiserver/metadata"
+	"dot/v2/apiserver/objects"
+	"dot/v2/rabbitmq"
+	"dot/v2/types"
+	"encoding/json"
+	"log"
+	"net/url"
+)
+
+// ListenReports 消费数据服务巡检上报的损坏对象并重建, 多个接口服务共同消费同一个队列
+func ListenReports() {
+	ctx := context.Background()
+	q, err := rabbitmq.Dial(ctx, types.ScrubQueue)
+	if err != nil {
+		log.Printf("Damage report listener stopped: %v", err)
+		return
+	}
+	defer q.Close()
+	msgs, err := q.Consume(ctx)
+	if err != nil {
+		log.Printf("Damage report listener stopped: %v", err)
+		return
+	}
+	for msg := range msgs {
+		var report types.DamageReport
+		if err := json.Unmarshal(msg.Body, &report); err != nil {
+			log.Printf("invalid damage report %q: %v", msg.Body, err)
+			continue
+		}
+		hash, err := url.PathUnescape(report.Hash)
+		if err != nil {
+			log.Printf("invalid damage report %q: %v", msg.Body, err)
+			continue
+		}
+		meta, err := metadata.SearchHash(hash)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if meta.Version == 0 {
+			log.Printf("damaged object %s is not referenced by any version, skip repair", report.Hash)
+			continue
+		}
+		err = objects.Repair(meta)
+		if err != nil {
+			log.Printf("repair object %s (shard %d on %s) fail: %v", report.Hash, report.Id, report.Addr, err)
+			continue
+		}
+		log.Printf("object %s repaired", report.Hash)
+	}
+}
@@ -0,0 +1,322 @@
+package s3
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	algorithm        = "AWS4-HMAC-SHA256"
+	amzTimeFormat    = "20060102T150405Z"
+	unsignedPayload  = "UNSIGNED-PAYLOAD"
+	streamingPayload = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	// emptyHash 空字符串的 SHA-256, 用于数据块签名
+	emptyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	maxSkew   = 15 * time.Minute
+	// maxChunkSize aws-chunked 编码中单个数据块的最大长度
+	maxChunkSize = 16 << 20
+)
+
+// signature 签名校验通过的请求, 用于继续校验 aws-chunked 请求体中各数据块的签名
+type signature struct {
+	key     []byte
+	date    string // X-Amz-Date
+	scope   string // <日期>/<区域>/s3/aws4_request
+	region  string
+	seed    string // 请求签名, 即第一个数据块的前一个签名
+	payload string // x-amz-content-sha256
+}
+
+// verify 校验请求的 SigV4 签名, 支持 Authorization 请求头与预签名 URL 两种方式
+//
+// 访问密钥由 S3_ACCESS_KEY 与 S3_SECRET_KEY 配置, 未配置时拒绝所有请求
+func verify(r *http.Request) (*signature, *apiError) {
+	accessKey, secretKey := os.Getenv("S3_ACCESS_KEY"), os.Getenv("S3_SECRET_KEY")
+	if accessKey == "" || secretKey == "" {
+		log.Println("S3_ACCESS_KEY or S3_SECRET_KEY is not configured")
+		return nil, errAccessDenied
+	}
+	query := r.URL.Query()
+	presigned := query.Has("X-Amz-Signature")
+	var fields map[string]string
+	if presigned {
+		fields = map[string]string{
+			"Algorithm":     query.Get("X-Amz-Algorithm"),
+			"Credential":    query.Get("X-Amz-Credential"),
+			"SignedHeaders": query.Get("X-Amz-SignedHeaders"),
+			"Signature":     query.Get("X-Amz-Signature"),
+			"Date":          query.Get("X-Amz-Date"),
+		}
+	} else {
+		auth := r.Header.Get("Authorization")
+		if auth == "" {
+			return nil, errAccessDenied
+		}
+		var e *apiError
+		fields, e = parseAuthorization(auth)
+		if e != nil {
+			return nil, e
+		}
+		fields["Date"] = r.Header.Get("X-Amz-Date")
+		if fields["Date"] == "" {
+			fields["Date"] = r.Header.Get("Date")
+		}
+	}
+	if fields["Algorithm"] != algorithm {
+		return nil, errUnsupportedAlgorithm
+	}
+	credential := strings.Split(fields["Credential"], "/")
+	if len(credential) != 5 || credential[3] != "s3" || credential[4] != "aws4_request" {
+		return nil, errMalformedAuth
+	}
+	if credential[0] != accessKey {
+		return nil, errInvalidAccessKeyId
+	}
+	t, err := time.Parse(amzTimeFormat, fields["Date"])
+	if err != nil {
+		t, err = http.ParseTime(fields["Date"])
+	}
+	if err != nil || !strings.HasPrefix(t.UTC().Format(amzTimeFormat), credential[1]) {
+		return nil, errMalformedAuth
+	}
+	if presigned {
+		expires, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+		if err != nil || expires < 0 || expires > 7*24*3600 {
+			return nil, errMalformedAuth
+		}
+		if time.Now().After(t.Add(time.Duration(expires) * time.Second)) {
+			return nil, errExpiredRequest
+		}
+	} else if d := time.Since(t); d > maxSkew || d < -maxSkew {
+		return nil, errRequestTimeTooSkewed
+	}
+
+	sig := &signature{
+		key:     signingKey(secretKey, credential[1], credential[2]),
+		date:    t.UTC().Format(amzTimeFormat),
+		scope:   strings.Join(credential[1:], "/"),
+		region:  credential[2],
+		payload: r.Header.Get("x-amz-content-sha256"),
+	}
+	if presigned || sig.payload == "" {
+		sig.payload = unsignedPayload
+	}
+	canonical := strings.Join([]string{
+		r.Method,
+		escape(r.URL.Path, false),
+		canonicalQuery(query),
+		canonicalHeaders(r, fields["SignedHeaders"]),
+		fields["SignedHeaders"],
+		sig.payload,
+	}, "\n")
+	sig.seed = sig.sign(algorithm + "\n" + sig.date + "\n" + sig.scope + "\n" + hashHex([]byte(canonical)))
+	if !hmac.Equal([]byte(sig.seed), []byte(fields["Signature"])) {
+		return nil, errSignatureDoesNotMatch
+	}
+	return sig, nil
+}
+
+// parseAuthorization 解析 Authorization 请求头:
+// AWS4-HMAC-SHA256 Credential=<凭证范围>, SignedHeaders=<请求头列表>, Signature=<签名>
+func parseAuthorization(auth string) (map[string]string, *apiError) {
+	alg, rest, ok := strings.Cut(auth, " ")
+	if !ok {
+		return nil, errMalformedAuth
+	}
+	fields := map[string]string{"Algorithm": alg}
+	for _, field := range strings.Split(rest, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			return nil, errMalformedAuth
+		}
+		fields[k] = v
+	}
+	if fields["Credential"] == "" || fields["SignedHeaders"] == "" || fields["Signature"] == "" {
+		return nil, errMalformedAuth
+	}
+	return fields, nil
+}
+
+func canonicalQuery(query url.Values) string {
+	params := make([][2]string, 0, len(query))
+	for k, vs := range query {
+		if k == "X-Amz-Signature" {
+			continue
+		}
+		for _, v := range vs {
+			params = append(params, [2]string{escape(k, true), escape(v, true)})
+		}
+	}
+	sort.Slice(params, func(i, j int) bool {
+		if params[i][0] != params[j][0] {
+			return params[i][0] < params[j][0]
+		}
+		return params[i][1] < params[j][1]
+	})
+	pairs := make([]string, len(params))
+	for i, p := range params {
+		pairs[i] = p[0] + "=" + p[1]
+	}
+	return strings.Join(pairs, "&")
+}
+
+func canonicalHeaders(r *http.Request, signedHeaders string) string {
+	var b strings.Builder
+	for _, name := range strings.Split(signedHeaders, ";") {
+		var values []string
+		if name == "host" {
+			values = []string{r.Host}
+		} else {
+			values = r.Header.Values(name)
+		}
+		if len(values) == 0 && name == "content-length" {
+			values = []string{strconv.FormatInt(r.ContentLength, 10)}
+		}
+		for i, v := range values {
+			values[i] = strings.Join(strings.Fields(v), " ")
+		}
+		b.WriteString(name + ":" + strings.Join(values, ",") + "\n")
+	}
+	return b.String()
+}
+
+// escape 按 SigV4 规则编码, 只保留字母数字与 -_.~, encodeSlash 为 false 时保留 /
+func escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' && !encodeSlash {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func signingKey(secretKey, date, region string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	return hmacSHA256(key, "aws4_request")
+}
+
+func (s *signature) sign(stringToSign string) string {
+	return hex.EncodeToString(hmacSHA256(s.key, stringToSign))
+}
+
+// chunkSignature 计算 aws-chunked 数据块的签名, prev 为上一个数据块的签名
+func (s *signature) chunkSignature(prev string, data []byte) string {
+	return s.sign("AWS4-HMAC-SHA256-PAYLOAD\n" + s.date + "\n" + s.scope + "\n" + prev + "\n" + emptyHash + "\n" + hashHex(data))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func hashHex(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+var (
+	errMalformedChunk = errors.New("malformed aws-chunked body")
+	errChunkSignature = errors.New("aws-chunked chunk signature mismatch")
+)
+
+// chunkedReader 解码 aws-chunked 编码的请求体: <十六进制长度>[;chunk-signature=<签名>]\r\n<数据>\r\n,
+// 以长度为 0 的数据块结束, 之后可能带有 trailer 校验和; sig 不为 nil 时逐块校验签名
+type chunkedReader struct {
+	r     *bufio.Reader
+	sig   *signature
+	prev  string
+	chunk []byte
+	err   error
+}
+
+func newChunkedReader(r io.Reader, sig *signature) *chunkedReader {
+	c := &chunkedReader{r: bufio.NewReader(r), sig: sig}
+	if sig != nil {
+		c.prev = sig.seed
+	}
+	return c
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	for len(c.chunk) == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+		c.err = c.next()
+	}
+	n := copy(p, c.chunk)
+	c.chunk = c.chunk[n:]
+	return n, nil
+}
+
+// next 读取下一个数据块, 读完最后一个数据块时返回 io.EOF
+func (c *chunkedReader) next() error {
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+	length, ext, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(length, 16, 64)
+	if err != nil || size < 0 || size > maxChunkSize {
+		return errMalformedChunk
+	}
+	data := make([]byte, size)
+	_, err = io.ReadFull(c.r, data)
+	if err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	if c.sig != nil {
+		chunkSig, ok := strings.CutPrefix(ext, "chunk-signature=")
+		if !ok || !hmac.Equal([]byte(chunkSig), []byte(c.sig.chunkSignature(c.prev, data))) {
+			return errChunkSignature
+		}
+		c.prev = chunkSig
+	}
+	if size == 0 {
+		for line != "" {
+			line, err = c.readLine()
+			if err != nil {
+				return err
+			}
+		}
+		return io.EOF
+	}
+	line, err = c.readLine()
+	if err != nil || line != "" {
+		return errMalformedChunk
+	}
+	c.chunk = data
+	return nil
+}
+
+func (c *chunkedReader) readLine() (string, error) {
+	line, err := c.r.ReadSlice('\n')
+	if err == io.EOF {
+		return "", io.ErrUnexpectedEOF
+	}
+	if err != nil || !strings.HasSuffix(string(line), "\r\n") {
+		return "", errMalformedChunk
+	}
+	return strings.TrimSuffix(string(line), "\r\n"), nil
+}
@@ -0,0 +1,300 @@
+package s3
+
+import (
+	"dot/v2/apiserver/buckets"
+	"dot/v2/apiserver/metadata"
+	"dot/v2/apiserver/objects"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const xmlns = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+// S3 的时间格式
+const isoTimeFormat = "2006-01-02T15:04:05.000Z"
+
+// checkBucket 存储桶不存在时返回 NoSuchBucket, 系统内部使用的存储桶同样视为不存在
+func checkBucket(bucket string) *apiError {
+	if _, ok := buckets.Get(bucket); !ok || !buckets.ValidName(bucket) {
+		return errNoSuchBucket
+	}
+	return nil
+}
+
+type listAllMyBucketsResult struct {
+	XMLName xml.Name `xml:"ListAllMyBucketsResult"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Owner   owner
+	Buckets []bucketEntry `xml:"Buckets>Bucket"`
+}
+
+type owner struct {
+	ID          string
+	DisplayName string
+}
+
+type bucketEntry struct {
+	Name         string
+	CreationDate string
+}
+
+func listBuckets(w http.ResponseWriter, r *http.Request) {
+	result := listAllMyBucketsResult{Xmlns: xmlns, Owner: owner{os.Getenv("S3_ACCESS_KEY"), os.Getenv("S3_ACCESS_KEY")}}
+	for _, b := range buckets.List() {
+		if buckets.ValidName(b.Name) {
+			result.Buckets = append(result.Buckets, bucketEntry{b.Name, b.Created.UTC().Format(isoTimeFormat)})
+		}
+	}
+	writeXML(w, result)
+}
+
+// createBucket 新建的存储桶使用默认策略, 可通过 /buckets/<b> 接口修改
+func createBucket(w http.ResponseWriter, r *http.Request, bucket string) {
+	if !buckets.ValidName(bucket) {
+		writeError(w, r, errInvalidBucketName)
+		return
+	}
+	_, err := buckets.Create(buckets.Bucket{Name: bucket, Versioning: true})
+	if errors.Is(err, buckets.ErrExists) {
+		writeError(w, r, errBucketAlreadyOwned)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		writeError(w, r, errInternalError)
+		return
+	}
+	w.Header().Set("Location", "/"+bucket)
+}
+
+func headBucket(w http.ResponseWriter, r *http.Request, bucket string) {
+	if e := checkBucket(bucket); e != nil {
+		writeError(w, r, e)
+	}
+}
+
+// deleteBucket 只能删除空的存储桶
+func deleteBucket(w http.ResponseWriter, r *http.Request, bucket string) {
+	if e := checkBucket(bucket); e != nil {
+		writeError(w, r, e)
+		return
+	}
+	err := buckets.Delete(bucket)
+	if errors.Is(err, buckets.ErrNotFound) {
+		writeError(w, r, errNoSuchBucket)
+		return
+	}
+	if errors.Is(err, buckets.ErrNotEmpty) {
+		writeError(w, r, errBucketNotEmpty)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		writeError(w, r, errInternalError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type locationConstraint struct {
+	XMLName xml.Name `xml:"LocationConstraint"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Region  string   `xml:",chardata"`
+}
+
+// getBucketLocation 返回客户端签名时使用的区域, 本系统不区分区域
+func getBucketLocation(w http.ResponseWriter, r *http.Request, bucket string, sig *signature) {
+	if e := checkBucket(bucket); e != nil {
+		writeError(w, r, e)
+		return
+	}
+	region := sig.region
+	if region == "us-east-1" {
+		region = ""
+	}
+	writeXML(w, locationConstraint{Xmlns: xmlns, Region: region})
+}
+
+type listEntry struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int64
+	StorageClass string
+}
+
+type commonPrefix struct {
+	Prefix string
+}
+
+type listBucketResult struct {
+	XMLName               xml.Name `xml:"ListBucketResult"`
+	Xmlns                 string   `xml:"xmlns,attr"`
+	Name                  string
+	Prefix                string
+	Marker                *string `xml:",omitempty"`
+	NextMarker            string  `xml:",omitempty"`
+	StartAfter            string  `xml:",omitempty"`
+	ContinuationToken     string  `xml:",omitempty"`
+	NextContinuationToken string  `xml:",omitempty"`
+	KeyCount              *int    `xml:",omitempty"`
+	MaxKeys               int
+	Delimiter             string `xml:",omitempty"`
+	EncodingType          string `xml:",omitempty"`
+	IsTruncated           bool
+	Contents              []listEntry
+	CommonPrefixes        []commonPrefix
+}
+
+// listObjects ListObjects 与 ListObjectsV2(list-type=2), 支持 prefix, delimiter 分组与分页
+func listObjects(w http.ResponseWriter, r *http.Request, bucket string) {
+	if e := checkBucket(bucket); e != nil {
+		writeError(w, r, e)
+		return
+	}
+	q := r.URL.Query()
+	v2 := q.Get("list-type") == "2"
+	prefix, delimiter := q.Get("prefix"), q.Get("delimiter")
+	maxKeys := 1000
+	if m := q.Get("max-keys"); m != "" {
+		n, err := strconv.Atoi(m)
+		if err != nil || n < 0 {
+			writeError(w, r, errInvalidArgument)
+			return
+		}
+		if n < maxKeys {
+			maxKeys = n
+		}
+	}
+	encodeKey := func(s string) string { return s }
+	if q.Get("encoding-type") == "url" {
+		encodeKey = func(s string) string { return escape(s, false) }
+	}
+
+	after := q.Get("marker")
+	if v2 {
+		after = q.Get("start-after")
+		if token := q.Get("continuation-token"); token != "" {
+			b, err := base64.RawURLEncoding.DecodeString(token)
+			if err != nil {
+				writeError(w, r, errInvalidArgument)
+				return
+			}
+			after = string(b)
+		}
+	}
+
+	// 元数据中的对象名为 <bucket>/<key>, 列出时加上存储桶前缀, 返回时去掉
+	root := objectName(bucket, "")
+	list := metadata.ListResult{}
+	if maxKeys != 0 {
+		var err error
+		list, err = metadata.List(root+prefix, delimiter, root+after, maxKeys)
+		if err != nil {
+			log.Println(err)
+			writeError(w, r, errInternalError)
+			return
+		}
+	}
+	result := listBucketResult{
+		Xmlns:        xmlns,
+		Name:         bucket,
+		Prefix:       encodeKey(prefix),
+		MaxKeys:      maxKeys,
+		Delimiter:    encodeKey(delimiter),
+		EncodingType: q.Get("encoding-type"),
+		IsTruncated:  list.IsTruncated,
+	}
+	for _, m := range list.Objects {
+		result.Contents = append(result.Contents, listEntry{
+			Key:          encodeKey(strings.TrimPrefix(m.Name, root)),
+			LastModified: m.Time.UTC().Format(isoTimeFormat),
+			ETag:         etag(m),
+			Size:         m.ObjectSize(),
+			StorageClass: "STANDARD",
+		})
+	}
+	for _, p := range list.CommonPrefixes {
+		result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{encodeKey(strings.TrimPrefix(p, root))})
+	}
+	last := strings.TrimPrefix(list.NextMarker, root)
+
+	if v2 {
+		count := len(result.Contents) + len(result.CommonPrefixes)
+		result.KeyCount = &count
+		result.StartAfter = encodeKey(q.Get("start-after"))
+		result.ContinuationToken = q.Get("continuation-token")
+		if result.IsTruncated {
+			result.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(last))
+		}
+	} else {
+		marker := encodeKey(after)
+		result.Marker = &marker
+		if result.IsTruncated {
+			result.NextMarker = encodeKey(last)
+		}
+	}
+	writeXML(w, result)
+}
+
+type deleteRequest struct {
+	Quiet   bool
+	Objects []struct {
+		Key string
+	} `xml:"Object"`
+}
+
+type deleteResult struct {
+	XMLName xml.Name `xml:"DeleteResult"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Deleted []deletedEntry
+	Errors  []deleteError `xml:"Error"`
+}
+
+type deletedEntry struct {
+	Key string
+}
+
+type deleteError struct {
+	Key     string
+	Code    string
+	Message string
+}
+
+// deleteObjects 批量删除对象, 不存在的对象同样视为删除成功
+func deleteObjects(w http.ResponseWriter, r *http.Request, bucket string) {
+	if e := checkBucket(bucket); e != nil {
+		writeError(w, r, e)
+		return
+	}
+	var req deleteRequest
+	b, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err == nil {
+		err = xml.Unmarshal(b, &req)
+	}
+	if err != nil {
+		writeError(w, r, errMalformedXML)
+		return
+	}
+	result := deleteResult{Xmlns: xmlns}
+	for _, o := range req.Objects {
+		rec := newStatusRecorder()
+		objects.Handler(rec, objectRequest(r, http.MethodDelete, objectName(bucket, o.Key), nil, 0))
+		if rec.status != http.StatusOK && rec.status != http.StatusNotFound {
+			e := statusError(rec.status)
+			result.Errors = append(result.Errors, deleteError{o.Key, e.Code, e.Message})
+			continue
+		}
+		if !req.Quiet {
+			result.Deleted = append(result.Deleted, deletedEntry{o.Key})
+		}
+	}
+	writeXML(w, result)
+}
@@ -0,0 +1,90 @@
+package s3
+
+import (
+	"encoding/xml"
+	"net/http"
+)
+
+// apiError S3 错误响应
+type apiError struct {
+	Code    string
+	Message string
+	Status  int
+}
+
+var (
+	errAccessDenied          = &apiError{"AccessDenied", "Access Denied.", http.StatusForbidden}
+	errExpiredRequest        = &apiError{"AccessDenied", "Request has expired.", http.StatusForbidden}
+	errMalformedAuth         = &apiError{"AuthorizationHeaderMalformed", "The authorization header is malformed.", http.StatusBadRequest}
+	errInvalidAccessKeyId    = &apiError{"InvalidAccessKeyId", "The access key Id you provided does not exist in our records.", http.StatusForbidden}
+	errSignatureDoesNotMatch = &apiError{"SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided.", http.StatusForbidden}
+	errRequestTimeTooSkewed  = &apiError{"RequestTimeTooSkewed", "The difference between the request time and the server's time is too large.", http.StatusForbidden}
+	errUnsupportedAlgorithm  = &apiError{"InvalidArgument", "Only AWS4-HMAC-SHA256 signatures are supported.", http.StatusBadRequest}
+	errInvalidArgument       = &apiError{"InvalidArgument", "Invalid argument.", http.StatusBadRequest}
+	errInvalidRequest        = &apiError{"InvalidRequest", "Invalid request.", http.StatusBadRequest}
+	errMissingContentLength  = &apiError{"MissingContentLength", "You must provide the Content-Length HTTP header.", http.StatusLengthRequired}
+	errIncompleteBody        = &apiError{"IncompleteBody", "You did not provide the number of bytes specified by the Content-Length HTTP header.", http.StatusBadRequest}
+	errBadDigest             = &apiError{"XAmzContentSHA256Mismatch", "The provided 'x-amz-content-sha256' header does not match what was computed.", http.StatusBadRequest}
+	errEntityTooLarge        = &apiError{"EntityTooLarge", "Your proposed upload exceeds the maximum allowed object size.", http.StatusBadRequest}
+	errMalformedXML          = &apiError{"MalformedXML", "The XML you provided was not well-formed or did not validate against our published schema.", http.StatusBadRequest}
+	errInvalidBucketName     = &apiError{"InvalidBucketName", "The specified bucket is not valid.", http.StatusBadRequest}
+	errNoSuchBucket          = &apiError{"NoSuchBucket", "The specified bucket does not exist.", http.StatusNotFound}
+	errBucketAlreadyOwned    = &apiError{"BucketAlreadyOwnedByYou", "Your previous request to create the named bucket succeeded and you already own it.", http.StatusConflict}
+	errBucketNotEmpty        = &apiError{"BucketNotEmpty", "The bucket you tried to delete is not empty.", http.StatusConflict}
+	errNoSuchKey             = &apiError{"NoSuchKey", "The specified key does not exist.", http.StatusNotFound}
+	errInvalidRange          = &apiError{"InvalidRange", "The requested range is not satisfiable.", http.StatusRequestedRangeNotSatisfiable}
+	errNoSuchUpload          = &apiError{"NoSuchUpload", "The specified multipart upload does not exist.", http.StatusNotFound}
+	errInvalidPart           = &apiError{"InvalidPart", "One or more of the specified parts could not be found.", http.StatusBadRequest}
+	errInvalidPartOrder      = &apiError{"InvalidPartOrder", "The list of parts was not in ascending order.", http.StatusBadRequest}
+	errPreconditionFailed    = &apiError{"PreconditionFailed", "At least one of the preconditions you specified did not hold.", http.StatusPreconditionFailed}
+	errMethodNotAllowed      = &apiError{"MethodNotAllowed", "The specified method is not allowed against this resource.", http.StatusMethodNotAllowed}
+	errNotImplemented        = &apiError{"NotImplemented", "A header or query you provided implies functionality that is not implemented.", http.StatusNotImplemented}
+	errServiceUnavailable    = &apiError{"ServiceUnavailable", "Please reduce your request rate.", http.StatusServiceUnavailable}
+	errInternalError         = &apiError{"InternalError", "We encountered an internal error. Please try again.", http.StatusInternalServerError}
+)
+
+// statusError objects.Handler 返回的状态码对应的 S3 错误
+func statusError(code int) *apiError {
+	switch code {
+	case http.StatusBadRequest:
+		return errInvalidRequest
+	case http.StatusForbidden:
+		return errAccessDenied
+	case http.StatusNotFound:
+		return errNoSuchKey
+	case http.StatusMethodNotAllowed:
+		return errMethodNotAllowed
+	case http.StatusPreconditionFailed:
+		return errPreconditionFailed
+	case http.StatusLengthRequired:
+		return errMissingContentLength
+	case http.StatusRequestEntityTooLarge:
+		return errEntityTooLarge
+	case http.StatusRequestedRangeNotSatisfiable:
+		return errInvalidRange
+	case http.StatusServiceUnavailable:
+		return errServiceUnavailable
+	}
+	return errInternalError
+}
+
+type errorResponse struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string
+	Message   string
+	Resource  string
+	RequestId string
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, e *apiError) {
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Del("Content-Range")
+	h.Del("ETag")
+	h.Del("Last-Modified")
+	h.Set("Content-Type", "application/xml")
+	w.WriteHeader(e.Status)
+	b, _ := xml.Marshal(errorResponse{Code: e.Code, Message: e.Message, Resource: r.URL.Path, RequestId: h.Get("x-amz-request-id")})
+	w.Write([]byte(xml.Header))
+	w.Write(b)
+}
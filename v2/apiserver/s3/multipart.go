@@ -0,0 +1,290 @@
+package s3
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"dot/v2/apiserver/buckets"
+	"dot/v2/apiserver/metadata"
+	"dot/v2/apiserver/objects"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 分段上传的每个分段作为系统内部存储桶 .multipart 中名为 <uploadId>/<partNumber> 的对象写入,
+// 完成上传时按顺序拼接写入目标对象后删除
+const partsBucket = ".multipart"
+
+// multipartUpload 未完成的分段上传, 保存在元数据目录下的 multipart/<uploadId>.json 中
+type multipartUpload struct {
+	Bucket    string
+	Key       string
+	Initiated time.Time
+	// Headers 初始化时给出的用户元数据与 Content-Type 等, 拼接完成后随对象保存
+	Headers map[string]string `json:",omitempty"`
+}
+
+func uploadFile(uploadId string) string {
+	return filepath.Join(metadata.Root(), "multipart", uploadId+".json")
+}
+
+func partName(uploadId string, partNumber int) string {
+	return objectName(partsBucket, uploadId+"/"+strconv.Itoa(partNumber))
+}
+
+// loadUpload 读取分段上传, 不存在或不属于该对象时返回 NoSuchUpload
+func loadUpload(uploadId, bucket, key string) (multipartUpload, *apiError) {
+	if uploadId == "" || strings.ContainsAny(uploadId, `/\.`) {
+		return multipartUpload{}, errNoSuchUpload
+	}
+	b, err := os.ReadFile(uploadFile(uploadId))
+	if errors.Is(err, os.ErrNotExist) {
+		return multipartUpload{}, errNoSuchUpload
+	}
+	if err != nil {
+		log.Println(err)
+		return multipartUpload{}, errInternalError
+	}
+	var u multipartUpload
+	err = json.Unmarshal(b, &u)
+	if err != nil {
+		log.Println(err)
+		return multipartUpload{}, errInternalError
+	}
+	if u.Bucket != bucket || u.Key != key {
+		return multipartUpload{}, errNoSuchUpload
+	}
+	return u, nil
+}
+
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Bucket   string
+	Key      string
+	UploadId string
+}
+
+func createMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	// 分段只在拼接前短暂保存, 重复上传同一分段时直接覆盖
+	_, err := buckets.Internal(partsBucket)
+	if err != nil {
+		log.Println(err)
+		writeError(w, r, errInternalError)
+		return
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	uploadId := hex.EncodeToString(id)
+	h := r.Header.Clone()
+	objectHeaders(h)
+	b, _ := json.Marshal(multipartUpload{bucket, key, time.Now().UTC(), objects.Headers(h)})
+	err = os.MkdirAll(filepath.Dir(uploadFile(uploadId)), 0755)
+	if err == nil {
+		err = os.WriteFile(uploadFile(uploadId), b, 0644)
+	}
+	if err != nil {
+		log.Println(err)
+		writeError(w, r, errInternalError)
+		return
+	}
+	writeXML(w, initiateMultipartUploadResult{Xmlns: xmlns, Bucket: bucket, Key: key, UploadId: uploadId})
+}
+
+func uploadPart(w http.ResponseWriter, r *http.Request, sig *signature, bucket, key string) {
+	q := r.URL.Query()
+	partNumber, err := strconv.Atoi(q.Get("partNumber"))
+	if err != nil || partNumber < 1 || partNumber > 10000 {
+		writeError(w, r, errInvalidArgument)
+		return
+	}
+	if _, e := loadUpload(q.Get("uploadId"), bucket, key); e != nil {
+		writeError(w, r, e)
+		return
+	}
+	storeData(w, r, sig, partName(q.Get("uploadId"), partNumber))
+}
+
+type completeRequest struct {
+	Parts []struct {
+		PartNumber int
+		ETag       string
+	} `xml:"Part"`
+}
+
+type completeMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Location string
+	Bucket   string
+	Key      string
+	ETag     string
+}
+
+// completeMultipartUpload 按顺序拼接各分段写入目标对象
+//
+// 写入对象前需要知道数据的散列值, 因此先读取一遍所有分段计算散列值, 再读取一遍写入
+func completeMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	uploadId := r.URL.Query().Get("uploadId")
+	u, e := loadUpload(uploadId, bucket, key)
+	if e != nil {
+		writeError(w, r, e)
+		return
+	}
+	var req completeRequest
+	b, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err == nil {
+		err = xml.Unmarshal(b, &req)
+	}
+	if err != nil || len(req.Parts) == 0 {
+		writeError(w, r, errMalformedXML)
+		return
+	}
+	parts := make([]metadata.Metadata, 0, len(req.Parts))
+	size := int64(0)
+	for i, p := range req.Parts {
+		if i > 0 && p.PartNumber <= req.Parts[i-1].PartNumber {
+			writeError(w, r, errInvalidPartOrder)
+			return
+		}
+		meta, err := metadata.SearchLatestVersion(partName(uploadId, p.PartNumber))
+		if err != nil {
+			log.Println(err)
+			writeError(w, r, errInternalError)
+			return
+		}
+		if meta.Version == 0 || meta.IsDeleteMarker() || `"`+strings.Trim(p.ETag, `"`)+`"` != etag(meta) {
+			writeError(w, r, errInvalidPart)
+			return
+		}
+		parts = append(parts, meta)
+		size += meta.Size
+	}
+
+	h := sha256.New()
+	pr := &partsReader{parts: parts}
+	_, err = io.Copy(h, pr)
+	pr.Close()
+	if err != nil {
+		log.Println(err)
+		writeError(w, r, errInternalError)
+		return
+	}
+	hash := base64.StdEncoding.EncodeToString(h.Sum(nil))
+	pr = &partsReader{parts: parts}
+	defer pr.Close()
+	req2 := objectRequest(r, http.MethodPut, objectName(bucket, key), pr, size)
+	// 对象的 Content-Type 与用户元数据来自初始化请求, 而不是本次请求
+	req2.Header = make(http.Header)
+	for k, v := range u.Headers {
+		req2.Header.Set(k, v)
+	}
+	req2.Header.Set("Digest", "SHA-256="+hash)
+	tag := multipartETag(parts)
+	if tag != "" {
+		req2 = req2.WithContext(objects.WithETag(req2.Context(), tag))
+	}
+	rec := newStatusRecorder()
+	objects.Handler(rec, req2)
+	if rec.status != http.StatusOK {
+		writeError(w, r, statusError(rec.status))
+		return
+	}
+
+	removeUpload(r, uploadId)
+	writeXML(w, completeMultipartUploadResult{
+		Xmlns:    xmlns,
+		Location: "/" + bucket + "/" + key,
+		Bucket:   bucket,
+		Key:      key,
+		ETag:     etag(metadata.Metadata{Hash: hash, ETag: tag}),
+	})
+}
+
+// multipartETag 分段上传对象的 ETag: 各分段 MD5 拼接后的 MD5 加 -<分段数>, 有分段没有 MD5 时为空
+func multipartETag(parts []metadata.Metadata) string {
+	h := md5.New()
+	for _, p := range parts {
+		sum, err := hex.DecodeString(p.ETag)
+		if err != nil || len(sum) != md5.Size {
+			return ""
+		}
+		h.Write(sum)
+	}
+	return hex.EncodeToString(h.Sum(nil)) + "-" + strconv.Itoa(len(parts))
+}
+
+func abortMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	uploadId := r.URL.Query().Get("uploadId")
+	if _, e := loadUpload(uploadId, bucket, key); e != nil {
+		writeError(w, r, e)
+		return
+	}
+	removeUpload(r, uploadId)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// removeUpload 删除分段上传已写入的所有分段
+func removeUpload(r *http.Request, uploadId string) {
+	parts, err := metadata.SearchLatestVersions(objectName(partsBucket, uploadId+"/"), "", 0)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for _, m := range parts {
+		objects.Handler(newStatusRecorder(), objectRequest(r, http.MethodDelete, m.Name, nil, 0))
+	}
+	err = os.Remove(uploadFile(uploadId))
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// partsReader 依次读取各个分段的数据
+type partsReader struct {
+	parts   []metadata.Metadata
+	current io.ReadCloser
+}
+
+func (p *partsReader) Read(b []byte) (int, error) {
+	for {
+		if p.current == nil {
+			if len(p.parts) == 0 {
+				return 0, io.EOF
+			}
+			reader, err := objects.NewReader(p.parts[0])
+			if err != nil {
+				return 0, err
+			}
+			p.current, p.parts = reader, p.parts[1:]
+		}
+		n, err := p.current.Read(b)
+		if err == io.EOF {
+			p.current.Close()
+			p.current = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (p *partsReader) Close() error {
+	if p.current == nil {
+		return nil
+	}
+	return p.current.Close()
+}
@@ -0,0 +1,175 @@
+package s3
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"dot/v2/apiserver/metadata"
+	"dot/v2/apiserver/objects"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// payload 解码请求体, 返回数据流, 数据大小与 base64 编码的 SHA-256 散列值,
+// x-amz-content-sha256 未给出散列值时(UNSIGNED-PAYLOAD 与 aws-chunked)散列值为空
+func payload(r *http.Request, sig *signature) (io.Reader, int64, string, *apiError) {
+	if strings.HasPrefix(sig.payload, "STREAMING-") {
+		size, err := strconv.ParseInt(r.Header.Get("x-amz-decoded-content-length"), 10, 64)
+		if err != nil || size < 0 {
+			return nil, 0, "", errMissingContentLength
+		}
+		// 只有 STREAMING-AWS4-HMAC-SHA256-PAYLOAD 的数据块带签名, *-TRAILER 与 UNSIGNED 变体不校验
+		if sig.payload == streamingPayload {
+			return newChunkedReader(r.Body, sig), size, "", nil
+		}
+		return newChunkedReader(r.Body, nil), size, "", nil
+	}
+	if r.ContentLength < 0 {
+		return nil, 0, "", errMissingContentLength
+	}
+	if sig.payload == unsignedPayload {
+		return r.Body, r.ContentLength, "", nil
+	}
+	h, err := hex.DecodeString(sig.payload)
+	if err != nil || len(h) != sha256.Size {
+		return nil, 0, "", errInvalidArgument
+	}
+	return r.Body, r.ContentLength, base64.StdEncoding.EncodeToString(h), nil
+}
+
+// spool 将数据暂存到本地临时文件并计算散列值, 用于事先不知道散列值的上传,
+// 调用方负责关闭并删除返回的文件
+func spool(r io.Reader, size int64) (*os.File, string, *apiError) {
+	f, err := objects.CreateSpool("s3-upload-")
+	if err != nil {
+		log.Println(err)
+		return nil, "", errInternalError
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, h), io.LimitReader(r, size+1))
+	if err == nil && n != size {
+		err = fmt.Errorf("request body size mismatch, actual=%d, expected=%d", n, size)
+	}
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		log.Println(err)
+		f.Close()
+		os.Remove(f.Name())
+		if errors.Is(err, errChunkSignature) {
+			return nil, "", errSignatureDoesNotMatch
+		}
+		return nil, "", errIncompleteBody
+	}
+	return f, base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// storeData 将请求体写入名为 name 的对象, 返回对象的 ETag, 写入失败时已经返回错误响应
+func storeData(w http.ResponseWriter, r *http.Request, sig *signature, name string) (string, bool) {
+	body, size, hash, e := payload(r, sig)
+	if e != nil {
+		writeError(w, r, e)
+		return "", false
+	}
+	if hash == "" {
+		f, h, e := spool(body, size)
+		if e != nil {
+			writeError(w, r, e)
+			return "", false
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+		body, hash = f, h
+	}
+	req := objectRequest(r, http.MethodPut, name, body, size)
+	req.Header.Set("Digest", "SHA-256="+hash)
+	rw := &responseWriter{ResponseWriter: w, r: r, errors: map[int]*apiError{http.StatusBadRequest: errBadDigest}}
+	objects.Handler(rw, req)
+	if rw.failed() {
+		return "", false
+	}
+	// objects.Handler 以 Content-MD5 返回数据的 MD5, SSE-C 对象没有 MD5, ETag 为加密后数据的散列值
+	tag := w.Header().Get("ETag")
+	if sum, err := base64.StdEncoding.DecodeString(w.Header().Get("Content-MD5")); err == nil && len(sum) == md5.Size {
+		tag = `"` + hex.EncodeToString(sum) + `"`
+	}
+	w.Header().Del("Content-MD5")
+	w.Header().Set("ETag", tag)
+	return tag, true
+}
+
+func putObject(w http.ResponseWriter, r *http.Request, sig *signature, bucket, key string) {
+	if _, ok := storeData(w, r, sig, objectName(bucket, key)); !ok {
+		return
+	}
+	amzCustomerKeyHeaders(w.Header())
+}
+
+// objectMetadata 查找对象的元数据, versionId 为空时返回最新版本
+func objectMetadata(r *http.Request, bucket, key string) (metadata.Metadata, *apiError) {
+	version := 0
+	if v := r.URL.Query().Get("versionId"); v != "" && v != "null" {
+		var err error
+		version, err = strconv.Atoi(v)
+		if err != nil || version <= 0 {
+			return metadata.Metadata{}, errInvalidArgument
+		}
+	}
+	meta, err := metadata.GetMetadata(objectName(bucket, key), version)
+	if err != nil || meta.Version == 0 || meta.IsDeleteMarker() {
+		return metadata.Metadata{}, errNoSuchKey
+	}
+	return meta, nil
+}
+
+func setObjectHeaders(w http.ResponseWriter, meta metadata.Metadata) {
+	w.Header().Set("ETag", etag(meta))
+	w.Header().Set("Last-Modified", meta.Time.UTC().Format(http.TimeFormat))
+	w.Header().Set("x-amz-version-id", strconv.Itoa(meta.Version))
+}
+
+func getObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	meta, e := objectMetadata(r, bucket, key)
+	if e != nil {
+		writeError(w, r, e)
+		return
+	}
+	setObjectHeaders(w, meta)
+	req := objectRequest(r, http.MethodGet, objectName(bucket, key), nil, 0)
+	req.URL.RawQuery = url.Values{"version": {strconv.Itoa(meta.Version)}}.Encode()
+	objects.Handler(&responseWriter{ResponseWriter: w, r: r, etag: etag(meta)}, req)
+}
+
+func headObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	meta, e := objectMetadata(r, bucket, key)
+	if e != nil {
+		writeError(w, r, e)
+		return
+	}
+	setObjectHeaders(w, meta)
+	req := objectRequest(r, http.MethodHead, objectName(bucket, key), nil, 0)
+	req.URL.RawQuery = url.Values{"version": {strconv.Itoa(meta.Version)}}.Encode()
+	rw := &responseWriter{ResponseWriter: w, r: r, etag: etag(meta)}
+	objects.Handler(rw, req)
+	rw.WriteHeader(http.StatusOK)
+}
+
+// deleteObject 删除不存在的对象同样返回成功
+func deleteObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	rec := newStatusRecorder()
+	objects.Handler(rec, objectRequest(r, http.MethodDelete, objectName(bucket, key), nil, 0))
+	if rec.status != http.StatusOK && rec.status != http.StatusNotFound {
+		writeError(w, r, statusError(rec.status))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
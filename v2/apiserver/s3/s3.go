@@ -0,0 +1,273 @@
+package s3
+
+import (
+	"crypto/rand"
+	"dot/v2/apiserver/metadata"
+	"dot/v2/apiserver/objects"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Handler S3 兼容接口, 只支持路径风格的请求: /<bucket>/<key>
+//
+// 存储桶与 /buckets 接口共用, 对象 key 对应 objects 接口中的 /objects/<bucket>/<key>,
+// 读写删除都转发给 objects.Handler 处理
+func Handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("x-amz-request-id", requestId())
+	sig, e := verify(r)
+	if e != nil {
+		writeError(w, r, e)
+		return
+	}
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	q := r.URL.Query()
+	method := r.Method
+	if bucket == "" {
+		if method == http.MethodGet {
+			listBuckets(w, r)
+			return
+		}
+		writeError(w, r, errMethodNotAllowed)
+		return
+	}
+	if key == "" {
+		if method == http.MethodPut {
+			createBucket(w, r, bucket)
+			return
+		}
+		if method == http.MethodHead {
+			headBucket(w, r, bucket)
+			return
+		}
+		if method == http.MethodDelete {
+			deleteBucket(w, r, bucket)
+			return
+		}
+		if method == http.MethodGet && q.Has("location") {
+			getBucketLocation(w, r, bucket, sig)
+			return
+		}
+		if method == http.MethodGet {
+			listObjects(w, r, bucket)
+			return
+		}
+		if method == http.MethodPost && q.Has("delete") {
+			deleteObjects(w, r, bucket)
+			return
+		}
+		writeError(w, r, errNotImplemented)
+		return
+	}
+
+	if e := checkBucket(bucket); e != nil {
+		writeError(w, r, e)
+		return
+	}
+	if e := checkCustomerKey(r, q); e != nil {
+		writeError(w, r, e)
+		return
+	}
+	if method == http.MethodPut && q.Has("uploadId") {
+		uploadPart(w, r, sig, bucket, key)
+		return
+	}
+	if method == http.MethodPut && r.Header.Get("x-amz-copy-source") == "" {
+		putObject(w, r, sig, bucket, key)
+		return
+	}
+	if method == http.MethodGet && !q.Has("uploadId") {
+		getObject(w, r, bucket, key)
+		return
+	}
+	if method == http.MethodHead {
+		headObject(w, r, bucket, key)
+		return
+	}
+	if method == http.MethodDelete && q.Has("uploadId") {
+		abortMultipartUpload(w, r, bucket, key)
+		return
+	}
+	if method == http.MethodDelete {
+		deleteObject(w, r, bucket, key)
+		return
+	}
+	if method == http.MethodPost && q.Has("uploads") {
+		createMultipartUpload(w, r, bucket, key)
+		return
+	}
+	if method == http.MethodPost && q.Has("uploadId") {
+		completeMultipartUpload(w, r, bucket, key)
+		return
+	}
+	writeError(w, r, errNotImplemented)
+}
+
+// objectName 存储桶中的对象在 objects 接口与元数据中的名字
+func objectName(bucket, key string) string {
+	return bucket + "/" + key
+}
+
+// objectRequest 构造转发给 objects.Handler 的请求, 保留原请求的 Range 等请求头
+func objectRequest(r *http.Request, method, name string, body io.Reader, size int64) *http.Request {
+	ctx := r.Context()
+	if strings.HasPrefix(name, partsBucket+"/") {
+		ctx = objects.WithInternal(ctx)
+	}
+	req := r.Clone(ctx)
+	req.Method = method
+	req.URL = &url.URL{Path: "/objects/" + name}
+	req.Header.Del("Digest")
+	objectHeaders(req.Header)
+	req.Body = http.NoBody
+	if body != nil {
+		req.Body = io.NopCloser(body)
+	}
+	req.ContentLength = size
+	return req
+}
+
+// amzMetaPrefix S3 用户元数据的请求头前缀
+const amzMetaPrefix = "X-Amz-Meta-"
+
+// objectHeaders 将 S3 请求头改写为 objects 接口的请求头: 用户元数据 x-amz-meta-* 对应 X-Meta-*,
+// SSE-C 的客户密钥见 customerKeyHeaders, aws-chunked 只是传输编码, 不随对象保存
+func objectHeaders(h http.Header) {
+	for key := range h {
+		if strings.HasPrefix(key, objects.MetaPrefix) {
+			delete(h, key)
+		}
+	}
+	for key, values := range h {
+		if strings.HasPrefix(key, amzMetaPrefix) {
+			h[objects.MetaPrefix+key[len(amzMetaPrefix):]] = values
+		}
+	}
+	customerKeyHeaders(h)
+	if encoding := contentEncoding(h); encoding != "" {
+		h.Set("Content-Encoding", encoding)
+	} else {
+		h.Del("Content-Encoding")
+	}
+}
+
+// contentEncoding 去掉 Content-Encoding 中的 aws-chunked, 剩下的是对象本身的编码
+func contentEncoding(h http.Header) string {
+	var encodings []string
+	for _, e := range strings.Split(h.Get("Content-Encoding"), ",") {
+		e = strings.TrimSpace(e)
+		if e != "" && !strings.EqualFold(e, "aws-chunked") {
+			encodings = append(encodings, e)
+		}
+	}
+	return strings.Join(encodings, ",")
+}
+
+// amzMetaHeaders 将 objects.Handler 响应中的 X-Meta-* 改为 x-amz-meta-*
+func amzMetaHeaders(h http.Header) {
+	for key, values := range h {
+		if strings.HasPrefix(key, objects.MetaPrefix) {
+			delete(h, key)
+			h[amzMetaPrefix+key[len(objects.MetaPrefix):]] = values
+		}
+	}
+}
+
+// responseWriter 转发 objects.Handler 的响应, 将其中的错误状态码转换为 S3 的 XML 错误
+type responseWriter struct {
+	http.ResponseWriter
+	r *http.Request
+	// errors 状态码对应的 S3 错误, 未列出的状态码使用 statusError
+	errors map[int]*apiError
+	// etag 不为空时替换 objects.Handler 返回的 ETag, 读取对象时返回与写入时相同的 S3 ETag
+	etag   string
+	status int
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = code
+	if code < http.StatusBadRequest {
+		amzMetaHeaders(w.Header())
+		amzCustomerKeyHeaders(w.Header())
+		if w.etag != "" {
+			w.Header().Set("ETag", w.etag)
+		}
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	e := w.errors[code]
+	if e == nil {
+		e = statusError(code)
+	}
+	writeError(w.ResponseWriter, w.r, e)
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.failed() {
+		return len(p), nil
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *responseWriter) failed() bool {
+	return w.status >= http.StatusBadRequest
+}
+
+// statusRecorder 只记录 objects.Handler 返回的状态码, 丢弃响应内容
+type statusRecorder struct {
+	header http.Header
+	status int
+}
+
+func newStatusRecorder() *statusRecorder {
+	return &statusRecorder{header: make(http.Header), status: http.StatusOK}
+}
+
+func (w *statusRecorder) Header() http.Header {
+	return w.header
+}
+
+func (w *statusRecorder) WriteHeader(code int) {
+	w.status = code
+}
+
+func (w *statusRecorder) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) {
+	b, err := xml.Marshal(v)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write([]byte(xml.Header))
+	w.Write(b)
+}
+
+func requestId() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return strings.ToUpper(hex.EncodeToString(b))
+}
+
+// etag 对象的 ETag, 与 S3 一致为数据 MD5 的十六进制值, 分段上传的对象为 <各分段 MD5 拼接后的 MD5>-<分段数>;
+// 写入时没有记录 MD5 的版本(SSE-C 对象等)与 objects 接口一致, 为 base64 SHA-256 散列值
+func etag(meta metadata.Metadata) string {
+	if meta.ETag != "" {
+		return `"` + meta.ETag + `"`
+	}
+	return `"` + meta.Hash + `"`
+}
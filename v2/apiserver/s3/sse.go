@@ -0,0 +1,50 @@
+package s3
+
+import (
+	"dot/v2/apiserver/objects"
+	"net/http"
+	"net/url"
+)
+
+// S3 客户提供密钥的服务端加密(SSE-C)请求头, 转发时改为 objects 接口的 X-Encryption-Customer-Key 等请求头
+const (
+	amzCustomerAlgorithm = "X-Amz-Server-Side-Encryption-Customer-Algorithm"
+	amzCustomerKey       = "X-Amz-Server-Side-Encryption-Customer-Key"
+	amzCustomerKeyMD5    = "X-Amz-Server-Side-Encryption-Customer-Key-Md5"
+)
+
+// checkCustomerKey 检查 SSE-C 请求头: 只支持 AES256 算法, 分段上传不支持 SSE-C
+func checkCustomerKey(r *http.Request, q url.Values) *apiError {
+	algorithm, key := r.Header.Get(amzCustomerAlgorithm), r.Header.Get(amzCustomerKey)
+	if algorithm == "" && key == "" {
+		return nil
+	}
+	if algorithm != "AES256" || key == "" {
+		return errInvalidArgument
+	}
+	if q.Has("uploads") || q.Has("uploadId") {
+		return errNotImplemented
+	}
+	return nil
+}
+
+// customerKeyHeaders 将请求中的 SSE-C 请求头改为 objects 接口的请求头, 不接受直接给出的 objects 接口请求头
+func customerKeyHeaders(h http.Header) {
+	h.Del(objects.CustomerKeyHeader)
+	h.Del(objects.CustomerKeyMD5Header)
+	if key := h.Get(amzCustomerKey); key != "" {
+		h.Set(objects.CustomerKeyHeader, key)
+		if md5 := h.Get(amzCustomerKeyMD5); md5 != "" {
+			h.Set(objects.CustomerKeyMD5Header, md5)
+		}
+	}
+}
+
+// amzCustomerKeyHeaders 将 objects.Handler 响应中的客户密钥 MD5 改为 S3 的响应头
+func amzCustomerKeyHeaders(h http.Header) {
+	if md5 := h.Get(objects.CustomerKeyMD5Header); md5 != "" {
+		h.Del(objects.CustomerKeyMD5Header)
+		h.Set(amzCustomerAlgorithm, "AES256")
+		h.Set(amzCustomerKeyMD5, md5)
+	}
+}
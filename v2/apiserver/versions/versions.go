@@ -0,0 +1,52 @@
+package versions
+
+import (
+	"dot/v2/apiserver/buckets"
+	"dot/v2/apiserver/metadata"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Handler 列出对象的所有历史版本: GET /versions/<bucket>/<key>, 路径为空时列出所有对象的版本;
+// 存储桶功能之前写入的对象为 /versions/<name>
+func Handler(w http.ResponseWriter, r *http.Request) {
+	m := r.Method
+	if m != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	// 与 objects 接口一致, 只有一段的路径为存储桶功能之前写入的对象, 以转义的路径为对象名
+	name := strings.TrimPrefix(r.URL.Path, "/versions/")
+	if escaped := strings.TrimPrefix(r.URL.EscapedPath(), "/versions/"); !strings.Contains(escaped, "/") {
+		name = escaped
+	}
+	if buckets.Reserved(name) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	metas, err := metadata.SearchAllVersions(name, 0, 0)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if name == "" {
+		// 不列出系统内部存储桶中的对象
+		visible := metas[:0]
+		for _, m := range metas {
+			if !buckets.Reserved(m.Name) {
+				visible = append(visible, m)
+			}
+		}
+		metas = visible
+	}
+	if name != "" && len(metas) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	b, _ := json.Marshal(metas)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
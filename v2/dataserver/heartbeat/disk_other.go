@@ -0,0 +1,8 @@
+//go:build !(linux || darwin || freebsd)
+
+package heartbeat
+
+// diskUsage 当前平台不支持统计磁盘空间, 总空间为 0 表示未知
+func diskUsage(path string) (free, total uint64) {
+	return 0, 0
+}
@@ -0,0 +1,14 @@
+//go:build linux || darwin || freebsd
+
+package heartbeat
+
+import "syscall"
+
+// diskUsage 返回 path 所在文件系统的剩余空间与总空间
+func diskUsage(path string) (free, total uint64) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), uint64(st.Blocks) * uint64(st.Bsize)
+}
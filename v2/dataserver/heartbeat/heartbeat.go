@@ -1,32 +1,49 @@
 package heartbeat
 
 import (
+	"context"
+	"dot/v2/dataserver/locate"
+	"dot/v2/dataserver/node"
 	"dot/v2/rabbitmq"
+	"dot/v2/types"
 	"log"
-	"os"
 	"time"
 )
 
-func StartHeartbeat() {
-	server := os.Getenv("RABBITMQ_SERVER")
+// StartHeartbeat 每 5 秒向 apiServers 交换机广播一次节点 n 的心跳, RabbitMQ 断开期间跳过心跳, 重连后继续;
+// 心跳以接口服务都能读取的格式版本发送, 并声明本节点能够读取的最高版本, 见 types.SendVersion
+func StartHeartbeat(n *node.Node) {
+	ctx := context.Background()
+	q, err := rabbitmq.Dial(ctx, "")
+	if err != nil {
+		log.Printf("Heartbeat stopped: %v", err)
+		return
+	}
+	defer q.Close()
 
 	for {
-		func() {
-			defer func() {
-				if r := recover(); r != nil {
-					log.Printf("Heartbeat panic recovered: %v", r)
-					time.Sleep(5 * time.Second) // 等待后重试
-				}
-			}()
-
-			q := rabbitmq.New(server)
-			defer q.Close()
+		body, err := types.SealMessage(types.SendVersion(), types.HeartbeatMessage, heartbeat(n))
+		if err == nil {
+			c, cancel := context.WithTimeout(ctx, 5*time.Second)
+			err = q.Publish(c, rabbitmq.ApiServersExchange, body)
+			cancel()
+		}
+		if err != nil {
+			log.Printf("Failed to send heartbeat: %v", err)
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
 
-			for {
-				address := os.Getenv("LISTEN_ADDRESS")
-				q.Publish("apiServers", address)
-				time.Sleep(5 * time.Second)
-			}
-		}()
+// heartbeat 收集节点的地址、容量与对象统计
+func heartbeat(n *node.Node) types.Heartbeat {
+	hb := types.Heartbeat{
+		Addr:           n.Addr,
+		Zone:           n.Zone,
+		Version:        types.Version,
+		MessageVersion: types.MessageVersion,
 	}
-}
\ No newline at end of file
+	hb.ObjectCount, hb.UsedBytes = locate.Of(n).Stats()
+	hb.FreeBytes, hb.TotalBytes = diskUsage(n.Root)
+	return hb
+}
@@ -0,0 +1,229 @@
+package keys
+
+import (
+	"dot/v2/dataserver/node"
+	"dot/v2/encryption"
+	"dot/v2/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// 加密保存的对象文件 <存储目录>/objects/<文件名>~aes 的数据密钥信封保存在 <存储目录>/keys/<文件名>~aes,
+// 轮换主密钥时只重写信封, 不重写数据
+//
+// mutex 保证读取时信封与数据文件一致: 打开文件, 提交新文件与轮换信封都在锁内进行
+var mutex sync.Mutex
+
+// Enabled 是否配置了主密钥, 配置后新写入的对象文件加密保存
+func Enabled() bool {
+	return encryption.DefaultKMS() != nil
+}
+
+// keysDir 对象文件 file 所在存储目录下的 keys 目录
+func keysDir(file string) string {
+	return filepath.Join(filepath.Dir(filepath.Dir(file)), "keys")
+}
+
+func envelopeFile(file string) string {
+	return filepath.Join(keysDir(file), filepath.Base(file))
+}
+
+func load(file string) (encryption.Envelope, error) {
+	var env encryption.Envelope
+	b, err := os.ReadFile(envelopeFile(file))
+	if err == nil {
+		err = json.Unmarshal(b, &env)
+	}
+	return env, err
+}
+
+// save 先写入临时文件再改名, 避免信封写到一半时进程退出
+func save(file string, env encryption.Envelope) error {
+	if err := os.MkdirAll(keysDir(file), 0700); err != nil {
+		return err
+	}
+	b, _ := json.Marshal(env)
+	tmp := envelopeFile(file) + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, envelopeFile(file))
+}
+
+// Commit 保存数据密钥信封, 并把以该数据密钥加密的临时文件 tmp 移到 fn
+func Commit(tmp, fn string, env encryption.Envelope) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if err := save(fn, env); err != nil {
+		return err
+	}
+	return os.Rename(tmp, fn)
+}
+
+// Encrypt 以随机的数据密钥将文件加密到 <文件名>~aes, 返回加密后的文件名与包装后的数据密钥
+func Encrypt(file string) (string, encryption.Envelope, error) {
+	key, env, err := encryption.NewDataKey(encryption.DefaultKMS())
+	if err != nil {
+		return "", env, err
+	}
+	src, err := os.Open(file)
+	if err != nil {
+		return "", env, err
+	}
+	defer src.Close()
+	encrypted := utils.EncryptedFileName(file)
+	dst, err := os.Create(encrypted)
+	if err != nil {
+		return "", env, err
+	}
+	defer dst.Close()
+	w, err := encryption.NewWriter(dst, key)
+	if err == nil {
+		_, err = io.Copy(w, src)
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		os.Remove(encrypted)
+		return "", env, err
+	}
+	return encrypted, env, nil
+}
+
+// Remove 删除对象文件的数据密钥信封, 未加密的文件没有信封
+func Remove(file string) {
+	if _, _, encrypted := utils.SplitFileName(filepath.Base(file)); encrypted {
+		os.Remove(envelopeFile(file))
+	}
+}
+
+// Open 打开对象文件, 加密保存的文件读取时解密, Seek 与大小都以明文计算;
+// 无法取得数据密钥时返回的错误包含 ErrKeyUnavailable, 数据块无法通过认证时 Read 返回错误
+func Open(fn string) (io.ReadSeekCloser, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, encrypted := utils.SplitFileName(filepath.Base(fn)); !encrypted {
+		return f, nil
+	}
+	r, err := decrypt(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// ErrKeyUnavailable 加密文件的数据密钥信封丢失, 或者没有配置包装它的主密钥
+var ErrKeyUnavailable = errors.New("data key unavailable")
+
+func decrypt(f *os.File) (io.ReadSeekCloser, error) {
+	env, err := load(f.Name())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrKeyUnavailable, err)
+	}
+	key, err := env.Open(encryption.DefaultKMS())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrKeyUnavailable, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	r, err := encryption.NewReader(f, info.Size(), key)
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		*encryption.Reader
+		io.Closer
+	}{r, f}, nil
+}
+
+// RotateResult 一次主密钥轮换的结果
+type RotateResult struct {
+	KeyId     string // 当前主密钥 id
+	Rewrapped int    // 重新包装的数据密钥数
+	Failed    int    // 无法解开的数据密钥数, 其主密钥可能已从密钥文件中删除
+}
+
+// Handler 轮换主密钥: POST /keys/rotate
+//
+// 在密钥文件末尾追加新主密钥后调用, 重新读取密钥文件, 以新主密钥重新包装所有数据密钥;
+// 返回结果中 Failed 为 0 后才能从密钥文件中删除旧主密钥
+func Handler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	kms := encryption.DefaultKMS()
+	if kms == nil {
+		log.Println("encryption is not enabled")
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	if reloader, ok := kms.(encryption.Reloader); ok {
+		if err := reloader.Reload(); err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	result, err := Rotate(node.FromContext(r.Context()), kms)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	b, _ := json.Marshal(result)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// Rotate 以 kms 的当前主密钥重新包装节点 n 上所有不是由它包装的数据密钥
+func Rotate(n *node.Node, kms encryption.KMS) (RotateResult, error) {
+	result := RotateResult{KeyId: kms.CurrentKeyId()}
+	entries, err := os.ReadDir(n.Dir("keys"))
+	if err != nil && !os.IsNotExist(err) {
+		return result, err
+	}
+	for _, e := range entries {
+		if _, _, encrypted := utils.SplitFileName(e.Name()); !encrypted || !e.Type().IsRegular() {
+			continue
+		}
+		rewrapped, err := rotate(kms, n.Dir("objects")+"/"+e.Name())
+		if err != nil {
+			log.Printf("Failed to rewrap data key of %s: %v", e.Name(), err)
+			result.Failed++
+		} else if rewrapped {
+			result.Rewrapped++
+		}
+	}
+	log.Printf("Rotated data keys to master key %s: %d rewrapped, %d failed", result.KeyId, result.Rewrapped, result.Failed)
+	return result, nil
+}
+
+func rotate(kms encryption.KMS, file string) (bool, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	env, err := load(file)
+	if err != nil {
+		return false, err
+	}
+	env, rewrapped, err := env.Rewrap(kms)
+	if err != nil || !rewrapped {
+		return false, err
+	}
+	return true, save(file, env)
+}
@@ -0,0 +1,185 @@
+package keys
+
+import (
+	"bytes"
+	"crypto/rand"
+	"dot/v2/dataserver/node"
+	"dot/v2/encryption"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// fakeKMS 进程内的主密钥服务, 记录每个主密钥包装了多少个数据密钥
+type fakeKMS struct {
+	mutex   sync.Mutex
+	keys    map[string][]byte
+	current string
+	wrapped map[string]int
+}
+
+func newFakeKMS() *fakeKMS {
+	return &fakeKMS{keys: make(map[string][]byte), wrapped: make(map[string]int)}
+}
+
+// add 加入主密钥并设为当前主密钥
+func (k *fakeKMS) add(id string) {
+	key := make([]byte, encryption.KeySize)
+	rand.Read(key)
+	k.mutex.Lock()
+	k.keys[id], k.current = key, id
+	k.mutex.Unlock()
+}
+
+func (k *fakeKMS) remove(id string) {
+	k.mutex.Lock()
+	delete(k.keys, id)
+	k.mutex.Unlock()
+}
+
+func (k *fakeKMS) CurrentKeyId() string {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	return k.current
+}
+
+func (k *fakeKMS) Wrap(keyId string, dataKey []byte) ([]byte, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	master, ok := k.keys[keyId]
+	if !ok {
+		return nil, fmt.Errorf("master key %q not found", keyId)
+	}
+	k.wrapped[keyId]++
+	return encryption.WrapKey(master, dataKey)
+}
+
+func (k *fakeKMS) Unwrap(keyId string, wrapped []byte) ([]byte, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	master, ok := k.keys[keyId]
+	if !ok {
+		return nil, fmt.Errorf("master key %q not found", keyId)
+	}
+	return encryption.UnwrapKey(master, wrapped)
+}
+
+// store 以当前主密钥在节点 n 上加密保存一个对象文件, 返回文件名与明文
+func store(t *testing.T, n *node.Node, name string) (string, []byte) {
+	if err := os.MkdirAll(n.Dir("objects"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	plain := make([]byte, encryption.ChunkSize+100)
+	rand.Read(plain)
+	src := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(src, plain, 0644); err != nil {
+		t.Fatal(err)
+	}
+	tmp, env, err := Encrypt(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn := n.Dir("objects") + "/" + filepath.Base(tmp)
+	if err := Commit(tmp, fn, env); err != nil {
+		t.Fatal(err)
+	}
+	return fn, plain
+}
+
+func read(t *testing.T, fn string) ([]byte, error) {
+	f, err := Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func TestRotate(t *testing.T) {
+	n := &node.Node{Root: t.TempDir()}
+	kms := newFakeKMS()
+	kms.add("k1")
+	encryption.SetKMS(kms)
+	defer encryption.SetKMS(nil)
+
+	files := make(map[string][]byte)
+	for _, name := range []string{"a", "b", "c"} {
+		fn, plain := store(t, n, name)
+		files[fn] = plain
+	}
+
+	// 当前主密钥没有变化时不重新包装
+	result, err := Rotate(n, kms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != (RotateResult{KeyId: "k1"}) {
+		t.Errorf("rotate without new key = %+v", result)
+	}
+
+	kms.add("k2")
+	result, err = Rotate(n, kms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != (RotateResult{KeyId: "k2", Rewrapped: 3}) {
+		t.Errorf("rotate to k2 = %+v, want 3 rewrapped", result)
+	}
+	if kms.wrapped["k2"] != 3 {
+		t.Errorf("k2 wrapped %d data keys, want 3", kms.wrapped["k2"])
+	}
+
+	// 所有数据密钥重新包装后删除旧主密钥, 数据仍然可读
+	kms.remove("k1")
+	for fn, plain := range files {
+		got, err := read(t, fn)
+		if err != nil {
+			t.Fatalf("%s: %v", fn, err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Errorf("%s: decrypted data differs", fn)
+		}
+	}
+
+	// 再次轮换没有需要重新包装的数据密钥
+	result, err = Rotate(n, kms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != (RotateResult{KeyId: "k2"}) {
+		t.Errorf("second rotate to k2 = %+v", result)
+	}
+}
+
+func TestRotateMissingKey(t *testing.T) {
+	n := &node.Node{Root: t.TempDir()}
+	kms := newFakeKMS()
+	kms.add("k1")
+	encryption.SetKMS(kms)
+	defer encryption.SetKMS(nil)
+	fn, _ := store(t, n, "a")
+
+	// 旧主密钥在轮换前被删除, 数据密钥无法解开, 轮换报告失败且不修改信封
+	kms.add("k2")
+	kms.remove("k1")
+	result, err := Rotate(n, kms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != (RotateResult{KeyId: "k2", Failed: 1}) {
+		t.Errorf("rotate = %+v, want 1 failed", result)
+	}
+	env, err := load(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.KeyId != "k1" {
+		t.Errorf("envelope rewrapped to %q after failed rotation", env.KeyId)
+	}
+	if _, err := read(t, fn); err == nil {
+		t.Error("read succeeded without master key")
+	}
+}
@@ -0,0 +1,173 @@
+package locate
+
+import (
+	"dot/v2/dataserver/node"
+	"dot/v2/encryption"
+	"dot/v2/types"
+	"dot/v2/utils"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// fileEntry 索引中的一个对象文件
+type fileEntry struct {
+	file string // objects 目录下的文件名
+	size int64  // 文件大小, 加密保存时为加密前的大小
+}
+
+// Index 一个节点的对象索引: 散列值 -> 分片id -> 文件, 完整对象的 id 为 types.WholeObject
+type Index struct {
+	dir        string // 节点的 objects 目录
+	objects    map[string]map[int]fileEntry
+	totalCount int
+	totalSize  int64
+	mutex      sync.RWMutex
+	// sorted 排好序的散列值, 供列出对象使用, 索引中增删散列值后置为 nil, 需要时重新排序
+	sorted []string
+}
+
+// 各节点的对象索引, 以存储目录区分
+var (
+	indexes      = make(map[string]*Index)
+	indexesMutex sync.Mutex
+)
+
+// Of 返回节点 n 的对象索引, 第一次调用时创建空索引
+func Of(n *node.Node) *Index {
+	indexesMutex.Lock()
+	defer indexesMutex.Unlock()
+	idx := indexes[n.Root]
+	if idx == nil {
+		idx = &Index{dir: n.Dir("objects"), objects: make(map[string]map[int]fileEntry)}
+		indexes[n.Root] = idx
+	}
+	return idx
+}
+
+// CollectObjects 启动时扫描节点的 objects 目录建立对象索引
+func CollectObjects(n *node.Node) {
+	idx := Of(n)
+	entries, err := os.ReadDir(idx.dir)
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to collect objects: %v", err)
+		return
+	}
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		// 加密保存的文件以加密前的大小计入索引
+		size := info.Size()
+		if _, _, encrypted := utils.SplitFileName(e.Name()); encrypted {
+			size = encryption.PlaintextSize(size)
+		}
+		idx.Add(e.Name(), size)
+	}
+	count, size := idx.Stats()
+	log.Printf("Collected %d objects, %d bytes", count, size)
+}
+
+// parseFileName 解析对象文件名: 完整对象为 <hash>, 纠删码分片为 <hash>.<id>.<分片hash>,
+// 压缩保存的文件名后另有 ~<压缩算法>, 加密保存的文件名最后另有 ~aes
+func parseFileName(file string) (hash string, id int, ok bool) {
+	file, _, _ = utils.SplitFileName(file)
+	parts := strings.Split(file, ".")
+	if len(parts) == 1 {
+		return file, types.WholeObject, true
+	}
+	if len(parts) != 3 {
+		return "", 0, false
+	}
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return "", 0, false
+	}
+	return parts[0], id, true
+}
+
+// Add 将 objects 目录下新写入的文件加入索引
+func (idx *Index) Add(file string, size int64) {
+	hash, id, ok := parseFileName(file)
+	if !ok {
+		return
+	}
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+	files := idx.objects[hash]
+	if files == nil {
+		files = make(map[int]fileEntry)
+		idx.objects[hash] = files
+		idx.sorted = nil
+	}
+	if old, exists := files[id]; exists {
+		idx.totalCount--
+		idx.totalSize -= old.size
+	}
+	files[id] = fileEntry{file, size}
+	idx.totalCount++
+	idx.totalSize += size
+}
+
+// Del 将 objects 目录下被删除的文件移出索引
+func (idx *Index) Del(file string) {
+	hash, id, ok := parseFileName(file)
+	if !ok {
+		return
+	}
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+	files := idx.objects[hash]
+	old, exists := files[id]
+	if !exists || old.file != file {
+		return
+	}
+	delete(files, id)
+	if len(files) == 0 {
+		delete(idx.objects, hash)
+		idx.sorted = nil
+	}
+	idx.totalCount--
+	idx.totalSize -= old.size
+}
+
+// Stats 索引中的文件数量与总字节数
+func (idx *Index) Stats() (count int, size int64) {
+	idx.mutex.RLock()
+	defer idx.mutex.RUnlock()
+	return idx.totalCount, idx.totalSize
+}
+
+// List 按散列值顺序列出散列值大于 marker 的对象文件, 最多 limit 个散列值
+func (idx *Index) List(marker string, limit int) types.ObjectList {
+	idx.mutex.Lock()
+	if idx.sorted == nil {
+		idx.sorted = make([]string, 0, len(idx.objects))
+		for hash := range idx.objects {
+			idx.sorted = append(idx.sorted, hash)
+		}
+		sort.Strings(idx.sorted)
+	}
+	hashes := idx.sorted
+	idx.mutex.Unlock()
+
+	idx.mutex.RLock()
+	defer idx.mutex.RUnlock()
+	list := types.ObjectList{Objects: []types.ObjectEntry{}}
+	i := sort.Search(len(hashes), func(i int) bool { return hashes[i] > marker })
+	for n := 0; i < len(hashes); i, n = i+1, n+1 {
+		if n == limit {
+			list.NextMarker = hashes[i-1]
+			break
+		}
+		for id, e := range idx.objects[hashes[i]] {
+			_, compression, _ := utils.SplitFileName(e.file)
+			list.Objects = append(list.Objects, types.ObjectEntry{Hash: hashes[i], Id: id, Size: e.size, Compression: compression})
+		}
+	}
+	return list
+}
@@ -0,0 +1,30 @@
+package locate
+
+import (
+	"dot/v2/dataserver/node"
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// ListHandler 列出处理请求的节点保存的对象文件: GET /list?marker=&limit=, 返回 JSON 格式的 types.ObjectList,
+// 供接口服务重建索引
+func ListHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	limit := 1000
+	if l := q.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	b, _ := json.Marshal(Of(node.FromContext(r.Context())).List(q.Get("marker"), limit))
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
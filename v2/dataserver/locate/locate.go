@@ -1,46 +1,67 @@
 package locate
 
 import (
+	"context"
+	"dot/v2/dataserver/node"
 	"dot/v2/rabbitmq"
+	"dot/v2/types"
+	"errors"
 	"log"
-	"os"
-	"strconv"
-	"time"
 )
 
-func Locate(name string) bool {
-	_, err := os.Stat(name)
-	return !os.IsNotExist(err)
+// Locate 从对象索引中查找对象在本地保存的文件, 返回 分片id -> 文件路径, 完整对象的 id 为 types.WholeObject
+//
+// 完整对象保存为 objects/<hash>, 纠删码分片保存为 objects/<hash>.<id>.<分片hash>, 压缩保存时文件名后另有 ~<压缩算法>
+func (idx *Index) Locate(object string) map[int]string {
+	idx.mutex.RLock()
+	defer idx.mutex.RUnlock()
+	files := make(map[int]string)
+	for id, e := range idx.objects[object] {
+		files[id] = idx.dir + "/" + e.file
+	}
+	return files
 }
 
-func StartLocate() {
-	server := os.Getenv("RABBITMQ_SERVER")
-
-	for {
-		func() {
-			defer func() {
-				if r := recover(); r != nil {
-					log.Printf("Locate service panic recovered: %v", r)
-					time.Sleep(5 * time.Second) // 等待后重试
-				}
-			}()
-
-			q := rabbitmq.New(server)
-			defer q.Close()
-			q.Bind("dataServers")
-
-			for msg := range q.Consume() {
-				object, err := strconv.Unquote(string(msg.Body))
-				if err != nil {
-					log.Printf("Failed to unquote message: %v", err)
-					continue
-				}
-				root := os.Getenv("STORAGE_ROOT")
-				name := root + "/objects/" + object
-				if Locate(name) {
-					q.Send(msg.ReplyTo, name)
-				}
+// StartLocate 回复接口服务通过 dataServers 交换机广播的定位请求, 回复节点 n 保存的文件,
+// RabbitMQ 重启后自动重连继续服务
+func StartLocate(n *node.Node) {
+	ctx := context.Background()
+	q, err := rabbitmq.Dial(ctx, rabbitmq.NodeQueue(rabbitmq.DataServersExchange, n.Id))
+	if err != nil {
+		log.Printf("Locate service stopped: %v", err)
+		return
+	}
+	defer q.Close()
+	// 绑定失败时会在重连后重试
+	if err := q.Bind(ctx, rabbitmq.DataServersExchange); err != nil {
+		log.Printf("Failed to bind dataServers: %v", err)
+	}
+	msgs, err := q.Consume(ctx)
+	if err != nil {
+		log.Printf("Locate service stopped: %v", err)
+		return
+	}
+	for msg := range msgs {
+		var object string
+		env, err := types.OpenMessage(msg.Body, types.LocateRequest, &object)
+		if err == nil && object == "" {
+			err = errors.New("missing object name")
+		}
+		if err != nil {
+			log.Printf("invalid locate request %q: %v", msg.Body, err)
+			continue
+		}
+		// 请求者能够读取它发送的格式版本; 版本 0 的请求没有节点 id, 都记在空 id 下
+		types.ObservePeer(env.Node, env.Version)
+		for id := range Of(n).Locate(object) {
+			// 以请求的格式版本回复, 尚未升级的接口服务也能读取
+			reply, err := types.SealMessage(env.Version, types.LocateReply, types.LocateMessage{Addr: n.Addr, Id: id})
+			if err == nil {
+				err = q.Reply(ctx, msg, reply)
 			}
-		}()
+			if err != nil {
+				log.Printf("Failed to reply locate message: %v", err)
+			}
+		}
 	}
-}
\ No newline at end of file
+}
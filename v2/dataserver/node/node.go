@@ -0,0 +1,60 @@
+package node
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// Node 一个数据服务节点的配置; 各节点使用独立的存储目录, 一个进程中可以运行多个节点
+type Node struct {
+	Id   string // 节点 id, 用于节点的持久化队列名
+	Addr string // 监听地址, 随心跳与定位回复发送给接口服务
+	Root string // 存储目录, 对象文件保存在 Root/objects
+	Zone string // 所在的可用区/机架
+}
+
+// FromEnv 按 NODE_ID, LISTEN_ADDRESS, STORAGE_ROOT 与 ZONE 创建节点, NODE_ID 默认为 LISTEN_ADDRESS
+func FromEnv() *Node {
+	n := &Node{
+		Id:   os.Getenv("NODE_ID"),
+		Addr: os.Getenv("LISTEN_ADDRESS"),
+		Root: os.Getenv("STORAGE_ROOT"),
+		Zone: os.Getenv("ZONE"),
+	}
+	if n.Id == "" {
+		n.Id = n.Addr
+	}
+	return n
+}
+
+// Default 由环境变量配置的节点, 第一次调用时读取配置
+var Default = sync.OnceValue(FromEnv)
+
+// Dir 存储目录下的子目录, 如 objects, temp, keys
+func (n *Node) Dir(name string) string {
+	return n.Root + "/" + name
+}
+
+type nodeKey struct{}
+
+// WithNode 返回带有节点 n 的 context
+func WithNode(ctx context.Context, n *Node) context.Context {
+	return context.WithValue(ctx, nodeKey{}, n)
+}
+
+// FromContext 返回 ctx 中的节点, 没有时为 Default
+func FromContext(ctx context.Context) *Node {
+	if n, ok := ctx.Value(nodeKey{}).(*Node); ok {
+		return n
+	}
+	return Default()
+}
+
+// Handler 在请求的 context 中带上节点 n 后交给 h 处理, 各接口由 FromContext 取得处理请求的节点
+func Handler(n *Node, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r.WithContext(WithNode(r.Context(), n)))
+	})
+}
@@ -0,0 +1,301 @@
+package scrub
+
+import (
+	"context"
+	"dot/v2/dataserver/keys"
+	"dot/v2/dataserver/locate"
+	"dot/v2/dataserver/node"
+	"dot/v2/rabbitmq"
+	"dot/v2/types"
+	"dot/v2/utils"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Status 巡检状态
+type Status struct {
+	Running      bool
+	LastStart    time.Time
+	LastFinish   time.Time
+	Scanned      int      // 本轮已校验的文件数
+	ScannedBytes int64    // 本轮已校验的字节数
+	Corrupted    int      // 累计发现的损坏文件数
+	Quarantined  []string // 最近隔离的文件, 最多 maxListed 个
+	// Damaged 最近发现的损坏但没有其它副本可用于重建的文件, 最多 maxListed 个; 这些文件保留在原处
+	Damaged []string
+}
+
+// maxListed 状态中最多列出的文件数
+const maxListed = 100
+
+// appendListed 追加到最多 maxListed 个的列表, 超出时丢弃最早的
+func appendListed(list []string, name string) []string {
+	list = append(list, name)
+	if len(list) > maxListed {
+		list = append(list[:0:0], list[len(list)-maxListed:]...)
+	}
+	return list
+}
+
+// scrubber 一个节点的巡检
+type scrubber struct {
+	n      *node.Node
+	status Status
+	mutex  sync.Mutex
+}
+
+// 各节点的巡检, 以存储目录区分
+var (
+	scrubbers      = make(map[string]*scrubber)
+	scrubbersMutex sync.Mutex
+)
+
+func of(n *node.Node) *scrubber {
+	scrubbersMutex.Lock()
+	defer scrubbersMutex.Unlock()
+	s := scrubbers[n.Root]
+	if s == nil {
+		s = &scrubber{n: n}
+		scrubbers[n.Root] = s
+	}
+	return s
+}
+
+// Handler 查看处理请求的节点的巡检状态: GET /scrub/status
+func Handler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	s := of(node.FromContext(r.Context()))
+	s.mutex.Lock()
+	b, _ := json.Marshal(s.status)
+	s.mutex.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// StartScrub 定期巡检节点 n 的 objects 目录, 重新计算每个文件的散列值
+//
+// 每隔 SCRUB_INTERVAL (默认 24h) 巡检一轮, 读取速度不超过 SCRUB_RATE 字节/秒 (默认 10MB/s),
+// 散列值不符的文件上报给接口服务重建; 有其它副本或分片可用于重建时先移入存储目录下的 quarantine,
+// 否则(如 single 策略的对象)隔离后对象将无法读取也无法重建, 保留在原处
+func StartScrub(n *node.Node) {
+	interval, err := time.ParseDuration(os.Getenv("SCRUB_INTERVAL"))
+	if err != nil || interval <= 0 {
+		interval = 24 * time.Hour
+	}
+	rate, err := strconv.ParseInt(os.Getenv("SCRUB_RATE"), 10, 64)
+	if err != nil || rate <= 0 {
+		rate = 10 << 20
+	}
+	s := of(n)
+	for {
+		s.scrubObjects(rate)
+		time.Sleep(interval)
+	}
+}
+
+func (s *scrubber) scrubObjects(rate int64) {
+	s.mutex.Lock()
+	s.status.Running = true
+	s.status.LastStart = time.Now()
+	s.status.Scanned = 0
+	s.status.ScannedBytes = 0
+	s.mutex.Unlock()
+
+	dir := s.n.Dir("objects")
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to scrub objects: %v", err)
+	}
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		n, ok := verify(dir+"/", e.Name(), rate)
+		s.mutex.Lock()
+		s.status.Scanned++
+		s.status.ScannedBytes += n
+		s.mutex.Unlock()
+		if !ok {
+			s.damaged(e.Name())
+		}
+	}
+
+	s.mutex.Lock()
+	s.status.Running = false
+	s.status.LastFinish = time.Now()
+	s.mutex.Unlock()
+}
+
+// verify 重新计算文件的散列值, 完整对象与文件名比较, 纠删码分片与文件名中的分片散列值比较
+//
+// 加密保存的文件校验解密后的数据, 数据块无法通过认证同样视为损坏
+func verify(dir, name string, rate int64) (int64, bool) {
+	f, err := keys.Open(dir + name)
+	if errors.Is(err, keys.ErrKeyUnavailable) {
+		// 无法解开数据密钥时不能判断文件是否损坏, 跳过
+		log.Printf("Failed to scrub %s: %v", name, err)
+		return 0, true
+	}
+	if err != nil {
+		// 巡检期间被删除的文件不算损坏
+		return 0, os.IsNotExist(err)
+	}
+	defer f.Close()
+	r := &throttledReader{r: f, rate: rate}
+	// 压缩保存的文件校验解压后的数据, 无法解压同样视为损坏
+	name, compression, _ := utils.SplitFileName(name)
+	var data io.Reader = r
+	if compression != "" {
+		d, err := utils.NewDecompressReader(r, compression)
+		if err != nil {
+			return r.n, false
+		}
+		defer d.Close()
+		data = d
+	}
+	// 无法解压或解密同样视为损坏
+	hash, err := utils.CalculateHash(data)
+	if err != nil {
+		return r.n, false
+	}
+	parts := strings.Split(name, ".")
+	return r.n, url.PathEscape(hash) == parts[len(parts)-1]
+}
+
+// damaged 处理损坏的文件: 可以重建时隔离并上报, 否则只记录并上报, 文件保留在原处
+func (s *scrubber) damaged(name string) {
+	if !s.redundant(name) {
+		log.Printf("corrupted object has no other copy, left in place: %s", name)
+		s.mutex.Lock()
+		s.status.Corrupted++
+		s.status.Damaged = appendListed(s.status.Damaged, name)
+		s.mutex.Unlock()
+		s.report(name)
+		return
+	}
+	s.quarantine(name)
+}
+
+// redundant 损坏的文件是否可以由其它数据重建: 纠删码分片总可以由其它分片重建;
+// 完整对象只有其它数据服务上也保存了完整对象(replica 策略)时才可以, single 策略的对象只有一份
+func (s *scrubber) redundant(name string) bool {
+	file, _, _ := utils.SplitFileName(name)
+	parts := strings.Split(file, ".")
+	if len(parts) == 3 {
+		return true
+	}
+	return s.otherReplica(parts[0])
+}
+
+// otherReplica 通过 dataServers 交换机广播定位请求, 判断其它数据服务上是否保存了完整对象;
+// 无法定位时视为没有, 宁可保留损坏的文件也不让唯一的一份数据不可读
+func (s *scrubber) otherReplica(object string) bool {
+	timeout, err := time.ParseDuration(os.Getenv("LOCATE_TIMEOUT"))
+	if err != nil || timeout <= 0 {
+		timeout = time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	q, err := rabbitmq.Open(ctx, "")
+	if err != nil {
+		log.Printf("Failed to locate replicas of %s: %v", object, err)
+		return false
+	}
+	defer q.Close()
+	c, err := q.Consume(ctx)
+	if err == nil {
+		var body any
+		body, err = types.SealMessage(types.SendVersion(), types.LocateRequest, object)
+		if err == nil {
+			err = q.Request(ctx, rabbitmq.DataServersExchange, "scrub", body)
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to locate replicas of %s: %v", object, err)
+		return false
+	}
+	for msg := range c {
+		var m types.LocateMessage
+		if _, err := types.OpenMessage(msg.Body, types.LocateReply, &m); err != nil {
+			continue
+		}
+		if m.Addr != s.n.Addr && m.Id == types.WholeObject {
+			return true
+		}
+	}
+	return false
+}
+
+// quarantine 隔离损坏的文件并上报
+func (s *scrubber) quarantine(name string) {
+	err := os.MkdirAll(s.n.Dir("quarantine"), 0755)
+	if err == nil {
+		err = os.Rename(s.n.Dir("objects")+"/"+name, s.n.Dir("quarantine")+"/"+name)
+	}
+	if err != nil {
+		log.Printf("Failed to quarantine %s: %v", name, err)
+		return
+	}
+	locate.Of(s.n).Del(name)
+	log.Printf("corrupted object quarantined: %s", name)
+
+	s.mutex.Lock()
+	s.status.Corrupted++
+	s.status.Quarantined = appendListed(s.status.Quarantined, name)
+	s.mutex.Unlock()
+
+	s.report(name)
+}
+
+// report 通知接口服务重建损坏的对象
+func (s *scrubber) report(name string) {
+	file, _, _ := utils.SplitFileName(name)
+	parts := strings.Split(file, ".")
+	id := types.WholeObject
+	if len(parts) == 3 {
+		id, _ = strconv.Atoi(parts[1])
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	q, err := rabbitmq.Open(ctx, types.ScrubQueue)
+	if err == nil {
+		defer q.Close()
+		err = q.Send(ctx, types.ScrubQueue, types.DamageReport{Addr: s.n.Addr, Hash: parts[0], Id: id})
+	}
+	if err != nil {
+		log.Printf("Failed to report corrupted object %s: %v", name, err)
+	}
+}
+
+// throttledReader 限制读取速度
+type throttledReader struct {
+	r     io.Reader
+	rate  int64
+	n     int64
+	start time.Time
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if t.start.IsZero() {
+		t.start = time.Now()
+	}
+	n, err := t.r.Read(p)
+	t.n += int64(n)
+	expected := time.Duration(float64(t.n) / float64(t.rate) * float64(time.Second))
+	if elapsed := time.Since(t.start); elapsed < expected {
+		time.Sleep(expected - elapsed)
+	}
+	return n, err
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"dot/v2/dataserver/heartbeat"
+	"dot/v2/dataserver/keys"
+	"dot/v2/dataserver/locate"
+	"dot/v2/dataserver/node"
+	"dot/v2/dataserver/scrub"
+	"dot/v2/dataserver/temp"
+	"dot/v2/objects"
+	"net/http"
+)
+
+// Start 建立节点 n 的对象索引, 并在后台启动心跳、定位、清理临时对象与巡检
+func Start(n *node.Node) {
+	// 建立对象索引
+	locate.CollectObjects(n)
+	// 心跳
+	go heartbeat.StartHeartbeat(n)
+	// 定位对象
+	go locate.StartLocate(n)
+	// 清理过期的临时对象
+	go temp.StartCleanup(n)
+	// 巡检对象, 隔离并上报损坏的文件
+	go scrub.StartScrub(n)
+}
+
+// Handler 节点 n 的 HTTP 接口
+func Handler(n *node.Node) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/objects/", objects.Handler)
+	mux.HandleFunc("/temp/", temp.Handler)
+	mux.HandleFunc("/scrub/status", scrub.Handler)
+	mux.HandleFunc("/list", locate.ListHandler)
+	mux.HandleFunc("/keys/rotate", keys.Handler)
+	return node.Handler(n, mux)
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"dot/v2/apiserver/buckets"
+	"dot/v2/apiserver/heartbeat"
+	apilocate "dot/v2/apiserver/locate"
+	"dot/v2/apiserver/objects"
+	"dot/v2/dataserver/locate"
+	"dot/v2/dataserver/node"
+	"dot/v2/rabbitmq"
+	"dot/v2/types"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startNode 在本地端口上启动一个使用独立存储目录的数据服务
+func startNode(t *testing.T, zone string) *node.Node {
+	n := &node.Node{Root: t.TempDir(), Zone: zone}
+	s := httptest.NewUnstartedServer(nil)
+	n.Addr = s.Listener.Addr().String()
+	n.Id = n.Addr
+	s.Config.Handler = Handler(n)
+	s.Start()
+	t.Cleanup(s.Close)
+	Start(n)
+	return n
+}
+
+func do(t *testing.T, method, url string, header http.Header, body []byte) *http.Response {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// TestCluster 在一个进程中以进程内的消息传输运行接口服务与三个数据服务, 写入纠删码对象后定位并读取
+func TestCluster(t *testing.T) {
+	rabbitmq.SetTransport(rabbitmq.NewMemory())
+	t.Setenv("STORAGE_ROOT", t.TempDir())
+	t.Setenv("METADATA_ROOT", t.TempDir())
+
+	go heartbeat.ListenHeartbeat()
+	nodes := []*node.Node{startNode(t, "a"), startNode(t, "b"), startNode(t, "c")}
+	// 数据服务每 5 秒发送一次心跳
+	for deadline := time.Now().Add(15 * time.Second); len(heartbeat.GetDataServers()) < len(nodes); time.Sleep(50 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d of %d data servers joined", len(heartbeat.GetDataServers()), len(nodes))
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/buckets/", buckets.Handler)
+	mux.HandleFunc("/objects/", objects.Handler)
+	mux.HandleFunc("/locate/", apilocate.Handler)
+	api := httptest.NewServer(mux)
+	defer api.Close()
+
+	resp := do(t, http.MethodPut, api.URL+"/buckets/photos", nil, []byte(`{"scheme":"rs-2-1"}`))
+	if resp.StatusCode/100 != 2 {
+		t.Fatalf("create bucket: %s", resp.Status)
+	}
+
+	data := make([]byte, 100000)
+	rand.Read(data)
+	sum := sha256.Sum256(data)
+	hash := base64.StdEncoding.EncodeToString(sum[:])
+	resp = do(t, http.MethodPut, api.URL+"/objects/photos/cat.jpg", http.Header{"Digest": {"SHA-256=" + hash}}, data)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("put object: %s", resp.Status)
+	}
+
+	// 每个数据服务保存一个分片, 各自的索引互不影响
+	resp = do(t, http.MethodGet, api.URL+"/locate/"+url.PathEscape(hash)+"?expected=3", nil, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("locate: %s", resp.Status)
+	}
+	var located map[string][]int
+	if err := json.NewDecoder(resp.Body).Decode(&located); err != nil {
+		t.Fatal(err)
+	}
+	shards := make(map[int]bool)
+	for _, n := range nodes {
+		ids := located[n.Addr]
+		if len(ids) != 1 || ids[0] == types.WholeObject {
+			t.Errorf("node %s holds %v, want one shard", n.Addr, ids)
+			continue
+		}
+		shards[ids[0]] = true
+		if count, _ := locate.Of(n).Stats(); count != 1 {
+			t.Errorf("node %s indexed %d files, want 1", n.Addr, count)
+		}
+	}
+	if len(shards) != len(nodes) {
+		t.Errorf("located shards %v, want %d distinct shards", located, len(nodes))
+	}
+
+	resp = do(t, http.MethodGet, api.URL+"/objects/photos/cat.jpg", nil, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("get object: %s", resp.Status)
+	}
+	got, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("read %d bytes, differs from the %d bytes written", len(got), len(data))
+	}
+
+	// 分片保存在各自的存储目录下, 文件名为 <hash>.<id>.<分片hash>
+	for _, n := range nodes {
+		for id, fn := range locate.Of(n).Locate(url.PathEscape(hash)) {
+			if !strings.HasPrefix(fn, n.Dir("objects")+"/") {
+				t.Errorf("shard %d of node %s stored at %s", id, n.Addr, fn)
+			}
+		}
+	}
+
+	// 压缩保存的单副本对象: HEAD 与 GET 协商相同的表示, 返回相同的 ETag
+	resp = do(t, http.MethodPut, api.URL+"/buckets/docs", nil, []byte(`{"scheme":"single","compression":"gzip"}`))
+	if resp.StatusCode/100 != 2 {
+		t.Fatalf("create bucket: %s", resp.Status)
+	}
+	text := []byte(strings.Repeat("hello, world\n", 1000))
+	sum = sha256.Sum256(text)
+	resp = do(t, http.MethodPut, api.URL+"/objects/docs/a.txt", http.Header{"Digest": {"SHA-256=" + base64.StdEncoding.EncodeToString(sum[:])}}, text)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("put compressed object: %s", resp.Status)
+	}
+	for _, accept := range []string{"gzip", "identity"} {
+		header := http.Header{"Accept-Encoding": {accept}}
+		get := do(t, http.MethodGet, api.URL+"/objects/docs/a.txt", header, nil)
+		body, _ := io.ReadAll(get.Body)
+		head := do(t, http.MethodHead, api.URL+"/objects/docs/a.txt", header, nil)
+		if head.Header.Get("ETag") != get.Header.Get("ETag") || head.Header.Get("Content-Encoding") != get.Header.Get("Content-Encoding") {
+			t.Errorf("Accept-Encoding %s: HEAD ETag %s %q, GET ETag %s %q", accept,
+				head.Header.Get("ETag"), head.Header.Get("Content-Encoding"), get.Header.Get("ETag"), get.Header.Get("Content-Encoding"))
+		}
+		if accept == "identity" && (!bytes.Equal(body, text) || head.ContentLength != int64(len(text))) {
+			t.Errorf("identity: GET %d bytes, HEAD length %d, want %d", len(body), head.ContentLength, len(text))
+		}
+		if accept == "gzip" && (get.Header.Get("Content-Encoding") != "gzip" || head.ContentLength != -1) {
+			t.Errorf("gzip: GET encoding %q, HEAD length %d", get.Header.Get("Content-Encoding"), head.ContentLength)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package temp
+
+import (
+	"dot/v2/dataserver/node"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// StartCleanup 定期清理节点 n 上超过 TEMP_TTL (默认 24h) 未更新的临时对象
+func StartCleanup(n *node.Node) {
+	ttl, err := time.ParseDuration(os.Getenv("TEMP_TTL"))
+	if err != nil || ttl <= 0 {
+		ttl = 24 * time.Hour
+	}
+	for {
+		time.Sleep(time.Minute)
+		removeExpiredTempObjects(n, ttl)
+	}
+}
+
+func removeExpiredTempObjects(n *node.Node, ttl time.Duration) {
+	entries, err := os.ReadDir(tempDir(n))
+	if err != nil {
+		return
+	}
+
+	// 信息文件与数据文件以各自最后修改时间中较晚者为准, PATCH 会刷新数据文件的修改时间
+	lastModified := make(map[string]time.Time)
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		id := strings.TrimSuffix(e.Name(), ".dat")
+		if t, ok := lastModified[id]; !ok || info.ModTime().After(t) {
+			lastModified[id] = info.ModTime()
+		}
+	}
+	for id, t := range lastModified {
+		if time.Since(t) < ttl {
+			continue
+		}
+		os.Remove(infoFile(n, id))
+		os.Remove(datFile(n, id))
+		log.Printf("expired temp object removed: %s", id)
+	}
+}
@@ -0,0 +1,133 @@
+package temp
+
+import (
+	"dot/v2/dataserver/keys"
+	"dot/v2/dataserver/locate"
+	"dot/v2/dataserver/node"
+	"dot/v2/encryption"
+	"dot/v2/types"
+	"dot/v2/utils"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// commitTempObject 校验临时对象后移入节点 n 的 objects 目录
+//
+// 完整对象以其散列值命名, 提交前校验散列值; 纠删码分片 <hash>.<id> 无法单独校验,
+// 提交时计算分片自身的散列值并追加到文件名中, 保存为 <hash>.<id>.<分片hash>;
+// 指定了压缩算法时校验后压缩保存, 文件名后追加 ~<压缩算法>; 配置了主密钥时最后以随机的数据密钥加密,
+// 文件名后再追加 ~aes, 包装后的数据密钥另行保存, 见 keys 包
+func commitTempObject(n *node.Node, datFile string, t *tempInfo) error {
+	tempData := datFile
+	f, err := os.Open(datFile)
+	if err != nil {
+		return err
+	}
+	hash, err := utils.CalculateHash(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	d := url.PathEscape(hash)
+	name := t.Name
+	if strings.Contains(name, ".") {
+		name = name + "." + d
+	} else if d != name {
+		return fmt.Errorf("object hash mismatch, calculated=%s, requested=%s", d, name)
+	}
+	err = os.MkdirAll(n.Dir("objects"), 0755)
+	if err != nil {
+		return err
+	}
+	size := t.Size
+	if t.Compression != "" {
+		var compressed string
+		compressed, size, err = compress(datFile, t.Compression)
+		if err != nil {
+			return err
+		}
+		// 压缩后没有变小的数据按原样保存
+		if size < t.Size {
+			datFile, name = compressed, utils.CompressedFileName(name, t.Compression)
+		} else {
+			os.Remove(compressed)
+			size = t.Size
+		}
+	}
+	var env encryption.Envelope
+	encrypted := keys.Enabled()
+	if encrypted {
+		var ciphertext string
+		ciphertext, env, err = keys.Encrypt(datFile)
+		// 压缩产生的中间文件不再需要, 原临时文件由调用方删除
+		if datFile != tempData {
+			os.Remove(datFile)
+		}
+		if err != nil {
+			return err
+		}
+		datFile, name = ciphertext, utils.EncryptedFileName(name)
+	}
+	fn := n.Dir("objects") + "/" + name
+	if encrypted {
+		err = keys.Commit(datFile, fn, env)
+	} else {
+		err = os.Rename(datFile, fn)
+	}
+	if err != nil {
+		os.Remove(datFile)
+		return err
+	}
+	// 同一对象或分片之前以另一种方式(压缩或未压缩)保存的文件不再需要
+	object, id := t.Name, types.WholeObject
+	if i := strings.Index(t.Name, "."); i != -1 {
+		object = t.Name[:i]
+		id, _ = strconv.Atoi(t.Name[i+1:])
+	}
+	idx := locate.Of(n)
+	if old, ok := idx.Locate(object)[id]; ok && filepath.Base(old) != name {
+		os.Remove(old)
+		keys.Remove(old)
+	}
+	// 索引中记录加密前的大小
+	idx.Add(name, size)
+	log.Printf("文件写入成功: %s", fn)
+	return nil
+}
+
+// compress 将文件压缩到 <文件名>~<压缩算法>, 返回压缩后的文件名与大小
+func compress(file, compression string) (string, int64, error) {
+	src, err := os.Open(file)
+	if err != nil {
+		return "", 0, err
+	}
+	defer src.Close()
+	compressed := utils.CompressedFileName(file, compression)
+	dst, err := os.Create(compressed)
+	if err != nil {
+		return "", 0, err
+	}
+	defer dst.Close()
+	w, err := utils.NewCompressWriter(dst, compression)
+	if err == nil {
+		_, err = io.Copy(w, src)
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	var info os.FileInfo
+	if err == nil {
+		info, err = dst.Stat()
+	}
+	if err != nil {
+		os.Remove(compressed)
+		return "", 0, err
+	}
+	return compressed, info.Size(), nil
+}
@@ -0,0 +1,262 @@
+package temp
+
+import (
+	"crypto/rand"
+	"dot/v2/dataserver/node"
+	"dot/v2/utils"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// tempInfo 临时对象的信息, 保存在 <存储目录>/temp/<uuid>, 数据保存在 <存储目录>/temp/<uuid>.dat
+type tempInfo struct {
+	Uuid string
+	Name string
+	Size int64
+	// Compression 转为正式对象时使用的压缩算法, 为空表示不压缩
+	Compression string `json:",omitempty"`
+
+	node *node.Node // 保存临时对象的节点
+}
+
+// Handler 临时对象接口
+//
+//	POST   /temp/<name>  创建临时对象, 请求头 Size 为对象大小, Compression 为保存时的压缩算法 gzip 或 zstd, 返回 uuid
+//	PATCH  /temp/<uuid>  追加写入数据
+//	PUT    /temp/<uuid>  确认上传, 校验后转为正式对象
+//	DELETE /temp/<uuid>  放弃上传
+//	HEAD   /temp/<uuid>  查询已写入的字节数
+//	GET    /temp/<uuid>  读取已写入的数据
+func Handler(w http.ResponseWriter, r *http.Request) {
+	method := r.Method
+	if method == http.MethodHead {
+		head(w, r)
+		return
+	}
+	if method == http.MethodGet {
+		get(w, r)
+		return
+	}
+	if method == http.MethodPost {
+		post(w, r)
+		return
+	}
+	if method == http.MethodPatch {
+		patch(w, r)
+		return
+	}
+	if method == http.MethodPut {
+		put(w, r)
+		return
+	}
+	if method == http.MethodDelete {
+		del(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusMethodNotAllowed)
+}
+
+func post(w http.ResponseWriter, r *http.Request) {
+	n := node.FromContext(r.Context())
+	name := strings.Split(r.URL.EscapedPath(), "/")[2]
+	size, err := strconv.ParseInt(r.Header.Get("Size"), 0, 64)
+	if err != nil || size < 0 {
+		log.Printf("invalid temp object size %q", r.Header.Get("Size"))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	compression := r.Header.Get("Compression")
+	if !utils.ValidCompression(compression) {
+		log.Printf("unsupported compression %q", compression)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	err = os.MkdirAll(tempDir(n), 0755)
+	if err != nil {
+		log.Println("目录创建失败", tempDir(n))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	t := tempInfo{Uuid: newUuid(), Name: name, Size: size, Compression: compression, node: n}
+	err = t.writeToFile()
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	f, err := os.Create(datFile(n, t.Uuid))
+	if err != nil {
+		log.Println(err)
+		os.Remove(infoFile(n, t.Uuid))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	f.Close()
+	w.Write([]byte(t.Uuid))
+}
+
+func patch(w http.ResponseWriter, r *http.Request) {
+	n := node.FromContext(r.Context())
+	uuid := strings.Split(r.URL.EscapedPath(), "/")[2]
+	t, err := readFromFile(n, uuid)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	f, err := os.OpenFile(datFile(n, uuid), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	// 最多写入到声明的大小, 多读一个字节判断请求体是否超出, 超出的数据不写入文件
+	remaining := t.Size - info.Size()
+	body := io.LimitReader(r.Body, remaining+1)
+	_, err = io.CopyN(f, body, remaining)
+	if err != nil && err != io.EOF {
+		log.Println("文件写入失败", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if _, err := io.ReadFull(body, make([]byte, 1)); err == nil {
+		t.remove()
+		log.Printf("temp object %s size exceeded, expected=%d", uuid, t.Size)
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func put(w http.ResponseWriter, r *http.Request) {
+	n := node.FromContext(r.Context())
+	uuid := strings.Split(r.URL.EscapedPath(), "/")[2]
+	t, err := readFromFile(n, uuid)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	defer t.remove()
+	info, err := os.Stat(datFile(n, uuid))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if info.Size() != t.Size {
+		log.Printf("temp object %s size mismatch, actual=%d, expected=%d", uuid, info.Size(), t.Size)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	err = commitTempObject(n, datFile(n, uuid), t)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func del(w http.ResponseWriter, r *http.Request) {
+	n := node.FromContext(r.Context())
+	uuid := strings.Split(r.URL.EscapedPath(), "/")[2]
+	t, err := readFromFile(n, uuid)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	t.remove()
+}
+
+func head(w http.ResponseWriter, r *http.Request) {
+	n := node.FromContext(r.Context())
+	uuid := strings.Split(r.URL.EscapedPath(), "/")[2]
+	if _, err := readFromFile(n, uuid); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	info, err := os.Stat(datFile(n, uuid))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+}
+
+func get(w http.ResponseWriter, r *http.Request) {
+	n := node.FromContext(r.Context())
+	uuid := strings.Split(r.URL.EscapedPath(), "/")[2]
+	if _, err := readFromFile(n, uuid); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	f, err := os.Open(datFile(n, uuid))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	io.Copy(w, f)
+}
+
+func (t *tempInfo) writeToFile() error {
+	b, _ := json.Marshal(t)
+	return os.WriteFile(infoFile(t.node, t.Uuid), b, 0644)
+}
+
+func (t *tempInfo) remove() {
+	os.Remove(infoFile(t.node, t.Uuid))
+	os.Remove(datFile(t.node, t.Uuid))
+}
+
+func readFromFile(n *node.Node, uuid string) (*tempInfo, error) {
+	if strings.ContainsAny(uuid, "/.") {
+		return nil, fmt.Errorf("invalid temp object uuid %q", uuid)
+	}
+	b, err := os.ReadFile(infoFile(n, uuid))
+	if err != nil {
+		return nil, err
+	}
+	t := tempInfo{node: n}
+	err = json.Unmarshal(b, &t)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func tempDir(n *node.Node) string {
+	return n.Dir("temp")
+}
+
+func infoFile(n *node.Node, uuid string) string {
+	return tempDir(n) + "/" + uuid
+}
+
+func datFile(n *node.Node, uuid string) string {
+	return tempDir(n) + "/" + uuid + ".dat"
+}
+
+// newUuid 生成随机的 version 4 UUID
+func newUuid() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
@@ -0,0 +1,54 @@
+package temp
+
+import (
+	"dot/v2/dataserver/node"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func request(h http.Handler, method, path string, header http.Header, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// TestPatchLimit 追加写入的数据不能超过创建时声明的大小, 超出时拒绝且不写入超出的部分
+func TestPatchLimit(t *testing.T) {
+	n := &node.Node{Root: t.TempDir()}
+	h := node.Handler(n, http.HandlerFunc(Handler))
+	create := func() string {
+		w := request(h, http.MethodPost, "/temp/object", http.Header{"Size": {"10"}}, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("create temp object: %d", w.Code)
+		}
+		return w.Body.String()
+	}
+
+	uuid := create()
+	for _, part := range []string{"hello", "world"} {
+		if w := request(h, http.MethodPatch, "/temp/"+uuid, nil, part); w.Code != http.StatusOK {
+			t.Fatalf("patch %q: %d", part, w.Code)
+		}
+	}
+	if b, _ := os.ReadFile(datFile(n, uuid)); string(b) != "helloworld" {
+		t.Errorf("temp object holds %q, want helloworld", b)
+	}
+	if w := request(h, http.MethodPatch, "/temp/"+uuid, nil, "!"); w.Code != http.StatusBadRequest {
+		t.Errorf("patch beyond the declared size: %d, want 400", w.Code)
+	}
+	if _, err := os.Stat(datFile(n, uuid)); !os.IsNotExist(err) {
+		t.Errorf("temp object kept after overflow: %v", err)
+	}
+
+	uuid = create()
+	if w := request(h, http.MethodPatch, "/temp/"+uuid, nil, strings.Repeat("x", 1<<20)); w.Code != http.StatusBadRequest {
+		t.Errorf("oversized patch: %d, want 400", w.Code)
+	}
+}
@@ -0,0 +1,61 @@
+package encryption
+
+import (
+	"crypto/rand"
+	"fmt"
+)
+
+// KeySize 数据密钥与主密钥的长度, 使用 AES-256
+const KeySize = 32
+
+// Envelope 对象文件的数据密钥, 由主密钥包装后与数据分开保存, 轮换主密钥时只需重新包装
+type Envelope struct {
+	KeyId      string // 包装数据密钥的主密钥 id
+	WrappedKey []byte
+}
+
+// NewDataKey 生成随机的数据密钥, 并用 k 的当前主密钥包装
+func NewDataKey(k KMS) ([]byte, Envelope, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, Envelope{}, err
+	}
+	id := k.CurrentKeyId()
+	wrapped, err := k.Wrap(id, key)
+	if err != nil {
+		return nil, Envelope{}, err
+	}
+	return key, Envelope{id, wrapped}, nil
+}
+
+// Open 解开信封中的数据密钥
+func (e Envelope) Open(k KMS) ([]byte, error) {
+	if k == nil {
+		return nil, fmt.Errorf("no master key configured to open data key wrapped by %q", e.KeyId)
+	}
+	key, err := k.Unwrap(e.KeyId, e.WrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("invalid data key size %d", len(key))
+	}
+	return key, nil
+}
+
+// Rewrap 以当前主密钥重新包装数据密钥, 已经是当前主密钥时返回 false
+func (e Envelope) Rewrap(k KMS) (Envelope, bool, error) {
+	id := k.CurrentKeyId()
+	if e.KeyId == id {
+		return e, false, nil
+	}
+	key, err := e.Open(k)
+	if err != nil {
+		return e, false, err
+	}
+	wrapped, err := k.Wrap(id, key)
+	if err != nil {
+		return e, false, err
+	}
+	return Envelope{id, wrapped}, true, nil
+}
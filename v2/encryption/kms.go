@@ -0,0 +1,208 @@
+package encryption
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// KMS 主密钥服务, 用主密钥包装(加密)每个对象的数据密钥, 主密钥本身不离开 KMS;
+// 默认实现为读取本地密钥文件的 Keyring, 也可替换为外部的密钥管理服务或测试用的进程内实现
+type KMS interface {
+	// CurrentKeyId 包装新数据密钥使用的主密钥 id
+	CurrentKeyId() string
+	// Wrap 用主密钥 keyId 加密数据密钥
+	Wrap(keyId string, dataKey []byte) ([]byte, error)
+	// Unwrap 用主密钥 keyId 解密数据密钥
+	Unwrap(keyId string, wrapped []byte) ([]byte, error)
+}
+
+// Reloader 可以重新加载主密钥的 KMS, 轮换主密钥前调用
+type Reloader interface {
+	Reload() error
+}
+
+var (
+	kms  KMS
+	once sync.Once
+)
+
+// SetKMS 替换默认的主密钥服务, 需在处理请求之前调用, k 为 nil 表示不加密
+func SetKMS(k KMS) {
+	once.Do(func() {})
+	kms = k
+}
+
+// DefaultKMS 返回主密钥服务, 默认读取 ENCRYPTION_KEY_FILE 指定的密钥文件, 未配置时返回 nil, 数据不加密
+func DefaultKMS() KMS {
+	once.Do(func() {
+		file := os.Getenv("ENCRYPTION_KEY_FILE")
+		if file == "" {
+			return
+		}
+		k, err := LoadKeyring(file)
+		if err != nil {
+			log.Fatalf("Failed to load encryption key file %s: %v", file, err)
+		}
+		kms = k
+	})
+	return kms
+}
+
+// Keyring 保存在进程内的一组主密钥, 最后加入的为当前主密钥, 旧主密钥仅用于解开轮换前包装的数据密钥
+type Keyring struct {
+	file    string
+	mutex   sync.RWMutex
+	keys    map[string][]byte
+	current string
+}
+
+// NewKeyring 由主密钥 id 到 32 字节 AES-256 密钥的映射创建 Keyring, current 为当前主密钥
+func NewKeyring(keys map[string][]byte, current string) (*Keyring, error) {
+	k := &Keyring{}
+	if err := k.set(keys, current); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// LoadKeyring 读取密钥文件, 每行为 "<主密钥id> <base64 编码的 32 字节密钥>", # 开头的行为注释;
+// 最后一行为当前主密钥, 轮换时在文件末尾追加新密钥, 所有数据密钥重新包装后才能删除旧密钥
+func LoadKeyring(file string) (*Keyring, error) {
+	k := &Keyring{file: file}
+	if err := k.Reload(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Reload 重新读取密钥文件
+func (k *Keyring) Reload() error {
+	if k.file == "" {
+		return nil
+	}
+	f, err := os.Open(k.file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	keys := make(map[string][]byte)
+	current := ""
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return fmt.Errorf("%s:%d: expected \"<id> <base64 key>\"", k.file, line)
+		}
+		key, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", k.file, line, err)
+		}
+		keys[fields[0]], current = key, fields[0]
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return k.set(keys, current)
+}
+
+func (k *Keyring) set(keys map[string][]byte, current string) error {
+	if _, ok := keys[current]; !ok {
+		return fmt.Errorf("current master key %q not found", current)
+	}
+	for id, key := range keys {
+		if len(id) > maxKeyIdLength || strings.ContainsAny(id, " \t\n") {
+			return fmt.Errorf("invalid master key id %q", id)
+		}
+		if len(key) != KeySize {
+			return fmt.Errorf("master key %s must be %d bytes, got %d", id, KeySize, len(key))
+		}
+	}
+	k.mutex.Lock()
+	k.keys, k.current = keys, current
+	k.mutex.Unlock()
+	return nil
+}
+
+// maxKeyIdLength 主密钥 id 的最大长度
+const maxKeyIdLength = 64
+
+func (k *Keyring) CurrentKeyId() string {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	return k.current
+}
+
+func (k *Keyring) Wrap(keyId string, dataKey []byte) ([]byte, error) {
+	master, err := k.key(keyId)
+	if err != nil {
+		return nil, err
+	}
+	return WrapKey(master, dataKey)
+}
+
+func (k *Keyring) Unwrap(keyId string, wrapped []byte) ([]byte, error) {
+	master, err := k.key(keyId)
+	if err != nil {
+		return nil, err
+	}
+	return UnwrapKey(master, wrapped)
+}
+
+func (k *Keyring) key(keyId string) ([]byte, error) {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	key, ok := k.keys[keyId]
+	if !ok {
+		return nil, fmt.Errorf("master key %q not found", keyId)
+	}
+	return key, nil
+}
+
+// WrapKey 用 AES-256-GCM 以 kek 加密数据密钥, 结果为随机 nonce 加密文
+func WrapKey(kek, dataKey []byte) ([]byte, error) {
+	aead, err := newAEAD(kek)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, dataKey, nil), nil
+}
+
+// UnwrapKey 解开 WrapKey 包装的数据密钥, kek 不正确时返回错误
+func UnwrapKey(kek, wrapped []byte) ([]byte, error) {
+	aead, err := newAEAD(kek)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, fmt.Errorf("wrapped key too short")
+	}
+	nonce, ciphertext := wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", KeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,236 @@
+package encryption
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// 加密数据的格式: 明文按 ChunkSize 分块, 每块用数据密钥以 AES-256-GCM 单独加密, 加密后多出 Overhead 字节的认证标签;
+// 第 i 块的 nonce 为 i 的大端序, 最后一块的 nonce 另有结束标记, 截断或调换数据块都无法通过认证.
+// 每个数据密钥只加密一份数据, 因此 nonce 不必随机; 按块加密使得解密时可以只读取 Range 请求的区间
+const (
+	ChunkSize = 64 << 10
+	Overhead  = 16
+)
+
+const encryptedChunkSize = ChunkSize + Overhead
+
+func chunkNonce(i int64, final bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce, uint64(i))
+	if final {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// ChunkOffset 明文中 offset 所在的块, 以及该块在加密数据中的起始位置
+func ChunkOffset(offset int64) (chunk, encryptedOffset int64) {
+	chunk = offset / ChunkSize
+	return chunk, chunk * encryptedChunkSize
+}
+
+// EncryptedSize 明文大小为 size 时加密数据的大小
+func EncryptedSize(size int64) int64 {
+	if size == 0 {
+		return Overhead
+	}
+	return size + (size+ChunkSize-1)/ChunkSize*Overhead
+}
+
+// PlaintextSize 加密数据大小为 size 时的明文大小
+func PlaintextSize(size int64) int64 {
+	if size <= Overhead {
+		return 0
+	}
+	return size - (size+encryptedChunkSize-1)/encryptedChunkSize*Overhead
+}
+
+type writer struct {
+	w    io.Writer
+	aead cipher.AEAD
+	buf  []byte
+	out  []byte
+	i    int64
+}
+
+// NewWriter 返回以 key 加密后写入 w 的 io.WriteCloser, Close 时写入最后一块, 不关闭 w
+func NewWriter(w io.Writer, key []byte) (io.WriteCloser, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &writer{w: w, aead: aead, buf: make([]byte, 0, ChunkSize), out: make([]byte, 0, encryptedChunkSize)}, nil
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	total := len(p)
+	for len(p) > 0 {
+		// 写满的块要等到有后续数据时才能确定不是最后一块
+		if len(w.buf) == ChunkSize {
+			if err := w.seal(false); err != nil {
+				return total - len(p), err
+			}
+		}
+		n := copy(w.buf[len(w.buf):ChunkSize], p)
+		w.buf, p = w.buf[:len(w.buf)+n], p[n:]
+	}
+	return total, nil
+}
+
+func (w *writer) Close() error {
+	return w.seal(true)
+}
+
+func (w *writer) seal(final bool) error {
+	w.out = w.aead.Seal(w.out[:0], chunkNonce(w.i, final), w.buf, nil)
+	w.buf = w.buf[:0]
+	w.i++
+	_, err := w.w.Write(w.out)
+	return err
+}
+
+// Reader 解密可随机读取的加密数据, 实现 io.ReadSeeker, 可直接交给 http.ServeContent 处理 Range 请求
+type Reader struct {
+	r      io.ReaderAt
+	aead   cipher.AEAD
+	size   int64 // 加密数据大小
+	plain  int64 // 明文大小
+	chunks int64
+	pos    int64
+	chunk  int64 // buf 中已解密的块, -1 表示没有
+	buf    []byte
+	in     []byte
+}
+
+// NewReader 解密 r 中大小为 size 的加密数据
+func NewReader(r io.ReaderAt, size int64, key []byte) (*Reader, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	plain := PlaintextSize(size)
+	if EncryptedSize(plain) != size {
+		return nil, fmt.Errorf("invalid encrypted data size %d", size)
+	}
+	chunks := (plain + ChunkSize - 1) / ChunkSize
+	if chunks == 0 {
+		chunks = 1
+	}
+	return &Reader{r: r, aead: aead, size: size, plain: plain, chunks: chunks, chunk: -1,
+		buf: make([]byte, 0, ChunkSize), in: make([]byte, encryptedChunkSize)}, nil
+}
+
+// Size 明文大小
+func (r *Reader) Size() int64 {
+	return r.plain
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	if r.pos >= r.plain {
+		return 0, io.EOF
+	}
+	i := r.pos / ChunkSize
+	if i != r.chunk {
+		if err := r.load(i); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf[r.pos-i*ChunkSize:])
+	r.pos += int64(n)
+	return n, nil
+}
+
+func (r *Reader) load(i int64) error {
+	start := i * encryptedChunkSize
+	in := r.in[:min(encryptedChunkSize, r.size-start)]
+	if _, err := r.r.ReadAt(in, start); err != nil {
+		return err
+	}
+	buf, err := r.aead.Open(r.buf[:0], chunkNonce(i, i == r.chunks-1), in, nil)
+	if err != nil {
+		r.chunk = -1
+		return fmt.Errorf("decrypt chunk %d: %w", i, err)
+	}
+	r.buf, r.chunk = buf, i
+	return nil
+}
+
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.plain
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.pos = offset
+	return offset, nil
+}
+
+type streamReader struct {
+	r    *bufio.Reader
+	aead cipher.AEAD
+	i    int64
+	buf  []byte
+	off  int
+	in   []byte
+	done bool
+}
+
+// NewStreamReader 顺序解密 r 中的加密数据, r 从第 chunk 块的开头读起, 见 ChunkOffset;
+// 数据被截断或篡改时 Read 返回错误
+func NewStreamReader(r io.Reader, key []byte, chunk int64) (io.Reader, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &streamReader{r: bufio.NewReaderSize(r, encryptedChunkSize), aead: aead, i: chunk,
+		buf: make([]byte, 0, ChunkSize), in: make([]byte, encryptedChunkSize)}, nil
+}
+
+func (s *streamReader) Read(p []byte) (int, error) {
+	for s.off == len(s.buf) {
+		if s.done {
+			return 0, io.EOF
+		}
+		if err := s.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, s.buf[s.off:])
+	s.off += n
+	return n, nil
+}
+
+// next 读取并解密下一块, 读不满一块或之后没有数据的为最后一块
+func (s *streamReader) next() error {
+	n, err := io.ReadFull(s.r, s.in)
+	final := err == io.EOF || err == io.ErrUnexpectedEOF
+	if err != nil && !final {
+		return err
+	}
+	if !final {
+		if _, err := s.r.Peek(1); err == io.EOF {
+			final = true
+		} else if err != nil {
+			return err
+		}
+	}
+	buf, err := s.aead.Open(s.buf[:0], chunkNonce(s.i, final), s.in[:n], nil)
+	if err != nil {
+		return fmt.Errorf("decrypt chunk %d: %w", s.i, err)
+	}
+	s.buf, s.off, s.done = buf, 0, final
+	s.i++
+	return nil
+}
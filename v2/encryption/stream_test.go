@@ -0,0 +1,260 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func testKey(t *testing.T) []byte {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func encrypt(t *testing.T, key, plain []byte) []byte {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 分多次写入, 覆盖写满一块后等待后续数据的情况
+	for p := plain; len(p) > 0; {
+		n := min(len(p), 1000)
+		if _, err := w.Write(p[:n]); err != nil {
+			t.Fatal(err)
+		}
+		p = p[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decryptStream(key, data []byte) ([]byte, error) {
+	r, err := NewStreamReader(bytes.NewReader(data), key, 0)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func decryptAt(key, data []byte) ([]byte, error) {
+	r, err := NewReader(bytes.NewReader(data), int64(len(data)), key)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestSize(t *testing.T) {
+	key := testKey(t)
+	tests := []struct {
+		plain     int64
+		encrypted int64
+	}{
+		{0, Overhead},
+		{1, 1 + Overhead},
+		{ChunkSize - 1, ChunkSize - 1 + Overhead},
+		{ChunkSize, ChunkSize + Overhead},
+		{ChunkSize + 1, ChunkSize + 1 + 2*Overhead},
+		{2 * ChunkSize, 2*ChunkSize + 2*Overhead},
+		{2*ChunkSize + 1, 2*ChunkSize + 1 + 3*Overhead},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.plain), func(t *testing.T) {
+			if got := EncryptedSize(tt.plain); got != tt.encrypted {
+				t.Errorf("EncryptedSize(%d) = %d, want %d", tt.plain, got, tt.encrypted)
+			}
+			if got := PlaintextSize(tt.encrypted); got != tt.plain {
+				t.Errorf("PlaintextSize(%d) = %d, want %d", tt.encrypted, got, tt.plain)
+			}
+			plain := make([]byte, tt.plain)
+			rand.Read(plain)
+			data := encrypt(t, key, plain)
+			if int64(len(data)) != tt.encrypted {
+				t.Fatalf("encrypted %d bytes to %d, want %d", tt.plain, len(data), tt.encrypted)
+			}
+			for name, decrypt := range map[string]func([]byte, []byte) ([]byte, error){"stream": decryptStream, "at": decryptAt} {
+				got, err := decrypt(key, data)
+				if err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+				if !bytes.Equal(got, plain) {
+					t.Errorf("%s: decrypted data differs", name)
+				}
+			}
+		})
+	}
+}
+
+func TestTampered(t *testing.T) {
+	key := testKey(t)
+	plain := make([]byte, 3*ChunkSize)
+	rand.Read(plain)
+	data := encrypt(t, key, plain)
+	chunk := func(i int) []byte {
+		return data[i*encryptedChunkSize : (i+1)*encryptedChunkSize]
+	}
+	join := func(chunks ...[]byte) []byte {
+		return bytes.Join(chunks, nil)
+	}
+	flipped := bytes.Clone(data)
+	flipped[ChunkSize+10] ^= 1
+	tests := []struct {
+		name string
+		data []byte
+		key  []byte // 为空时使用加密时的密钥
+	}{
+		// 在块的边界截断, 剩下的每块都能解密, 只有最后一块的结束标记能发现截断
+		{name: "truncated at chunk boundary", data: join(chunk(0), chunk(1))},
+		{name: "truncated inside chunk", data: data[:len(data)-1]},
+		{name: "reordered", data: join(chunk(1), chunk(0), chunk(2))},
+		{name: "final chunk moved", data: join(chunk(0), chunk(2), chunk(1))},
+		{name: "chunk repeated", data: join(chunk(0), chunk(0), chunk(1), chunk(2))},
+		{name: "bit flipped", data: flipped},
+		{name: "wrong key", data: data, key: testKey(t)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := key
+			if tt.key != nil {
+				k = tt.key
+			}
+			if _, err := decryptStream(k, tt.data); err == nil {
+				t.Error("stream: tampered data decrypted without error")
+			}
+			if _, err := decryptAt(k, tt.data); err == nil {
+				t.Error("at: tampered data decrypted without error")
+			}
+		})
+	}
+}
+
+func TestStreamReaderFromChunk(t *testing.T) {
+	key := testKey(t)
+	plain := make([]byte, 2*ChunkSize+100)
+	rand.Read(plain)
+	data := encrypt(t, key, plain)
+	for _, offset := range []int64{0, 1, ChunkSize - 1, ChunkSize, ChunkSize + 1, 2*ChunkSize + 99} {
+		chunk, encryptedOffset := ChunkOffset(offset)
+		r, err := NewStreamReader(bytes.NewReader(data[encryptedOffset:]), key, chunk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.CopyN(io.Discard, r, offset-chunk*ChunkSize); err != nil {
+			t.Fatalf("offset %d: %v", offset, err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("offset %d: %v", offset, err)
+		}
+		if !bytes.Equal(got, plain[offset:]) {
+			t.Errorf("offset %d: decrypted data differs", offset)
+		}
+	}
+}
+
+func TestReaderSeek(t *testing.T) {
+	key := testKey(t)
+	plain := make([]byte, 2*ChunkSize+100)
+	rand.Read(plain)
+	data := encrypt(t, key, plain)
+	r, err := NewReader(bytes.NewReader(data), int64(len(data)), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		offset int64
+		whence int
+		pos    int64
+	}{
+		{ChunkSize + 5, io.SeekStart, ChunkSize + 5},
+		{-10, io.SeekEnd, int64(len(plain)) - 10},
+		{-ChunkSize, io.SeekCurrent, int64(len(plain)) - 10 - ChunkSize},
+		{0, io.SeekStart, 0},
+	}
+	for _, tt := range tests {
+		pos, err := r.Seek(tt.offset, tt.whence)
+		if err != nil || pos != tt.pos {
+			t.Fatalf("Seek(%d, %d) = %d, %v, want %d", tt.offset, tt.whence, pos, err, tt.pos)
+		}
+		buf := make([]byte, 10)
+		n, err := io.ReadFull(r, buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf[:n], plain[pos:pos+10]) {
+			t.Errorf("read after Seek(%d, %d) differs", tt.offset, tt.whence)
+		}
+		r.Seek(pos, io.SeekStart)
+	}
+	if _, err := r.Seek(-1, io.SeekStart); err == nil {
+		t.Error("Seek to negative position succeeded")
+	}
+	r.Seek(int64(len(plain)), io.SeekStart)
+	if n, err := r.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Errorf("Read at end = %d, %v, want 0, EOF", n, err)
+	}
+}
+
+func TestReaderServeContent(t *testing.T) {
+	key := testKey(t)
+	size := int64(2*ChunkSize + 100)
+	plain := make([]byte, size)
+	rand.Read(plain)
+	data := encrypt(t, key, plain)
+	tests := []struct {
+		rangeHeader string
+		status      int
+		start, end  int64 // 期望返回的明文区间 [start, end)
+	}{
+		{"", http.StatusOK, 0, size},
+		{"bytes=0-9", http.StatusPartialContent, 0, 10},
+		{"bytes=65530-65545", http.StatusPartialContent, ChunkSize - 6, ChunkSize + 10},
+		{fmt.Sprintf("bytes=%d-", ChunkSize), http.StatusPartialContent, ChunkSize, size},
+		{"bytes=-50", http.StatusPartialContent, size - 50, size},
+		{fmt.Sprintf("bytes=%d-", size), http.StatusRequestedRangeNotSatisfiable, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rangeHeader, func(t *testing.T) {
+			r, err := NewReader(bytes.NewReader(data), int64(len(data)), key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.rangeHeader != "" {
+				req.Header.Set("Range", tt.rangeHeader)
+			}
+			rec := httptest.NewRecorder()
+			http.ServeContent(rec, req, "", time.Time{}, r)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d", rec.Code, tt.status)
+			}
+			if tt.status == http.StatusRequestedRangeNotSatisfiable {
+				return
+			}
+			if !bytes.Equal(rec.Body.Bytes(), plain[tt.start:tt.end]) {
+				t.Errorf("body of %d bytes differs from plaintext [%d, %d)", rec.Body.Len(), tt.start, tt.end)
+			}
+		})
+	}
+}
+
+func TestNewReaderInvalidSize(t *testing.T) {
+	key := testKey(t)
+	// 这些大小不是任何明文加密后的大小, 如最后一块不足 Overhead 字节
+	for _, size := range []int64{0, Overhead - 1, encryptedChunkSize + 1, encryptedChunkSize + Overhead} {
+		if _, err := NewReader(bytes.NewReader(make([]byte, size)), size, key); err == nil {
+			t.Errorf("NewReader accepted invalid encrypted size %d", size)
+		}
+	}
+}
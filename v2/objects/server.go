@@ -1,11 +1,21 @@
 package objects
 
 import (
+	"crypto/sha256"
+	"dot/v2/dataserver/keys"
+	"dot/v2/dataserver/locate"
+	"dot/v2/dataserver/node"
+	"dot/v2/types"
+	"dot/v2/utils"
+	"encoding/base64"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 )
 
@@ -17,60 +27,169 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	if method == http.MethodGet {
+	// ServeContent 对 HEAD 只返回响应头, 不读取文件
+	if method == http.MethodGet || method == http.MethodHead {
 		if err := get(w, r); err != nil {
 			log.Print(err)
 		}
 	}
+
+	if method == http.MethodDelete {
+		if err := del(w, r); err != nil {
+			log.Print(err)
+		}
+	}
 }
 
 func put(w http.ResponseWriter, r *http.Request) error {
-	fn := os.Getenv("STORAGE_ROOT") + "/objects/" + strings.Split(r.URL.EscapedPath(), "/")[2]
-	
+	// 对象以其 SHA-256 散列值(经 url.PathEscape 转义)命名
+	name := strings.Split(r.URL.EscapedPath(), "/")[2]
+	n := node.FromContext(r.Context())
+
 	// tip: `os.Create()`不可自动创建中间目录
-	dir := filepath.Dir(fn)
-	err := os.MkdirAll(dir, 0755)
-	if err != nil {
-		log.Println("目录创建失败", dir)
-		w.WriteHeader(http.StatusInternalServerError)
-		return err
+	for _, dir := range []string{n.Dir("objects"), n.Dir("temp")} {
+		err := os.MkdirAll(dir, 0755)
+		if err != nil {
+			log.Println("目录创建失败", dir)
+			w.WriteHeader(http.StatusInternalServerError)
+			return err
+		}
 	}
 
-	// `strings.Split(r.URL.EscapedPath(), "/")[2]` : URL 路径部分的第二级参数
-	f, err := os.Create(fn)
+	// 先写入临时文件, 校验通过后再移入 objects 目录, 避免损坏的数据落盘
+	f, err := os.CreateTemp(n.Dir("temp"), "put-*")
 	if err != nil {
-		log.Println("文件创建失败", strings.Split(r.URL.EscapedPath(), "/")[2])
+		log.Println("文件创建失败", name)
 		w.WriteHeader(http.StatusInternalServerError)
 		return err
 	}
+	defer os.Remove(f.Name())
 	defer f.Close()
-	// 覆盖写
-	_, err = io.Copy(f, r.Body)
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, h), r.Body)
 	if err != nil {
 		log.Println("文件写入失败")
 		w.WriteHeader(http.StatusInternalServerError)
 		return err
 	}
 
+	d := url.PathEscape(base64.StdEncoding.EncodeToString(h.Sum(nil)))
+	if d != name {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("object hash mismatch, calculated=%s, requested=%s", d, name)
+	}
+
+	// 配置了主密钥时加密保存, 与确认上传的临时对象相同
+	file := f.Name()
+	if keys.Enabled() {
+		encrypted, env, err := keys.Encrypt(file)
+		if err == nil {
+			name = utils.EncryptedFileName(name)
+			err = keys.Commit(encrypted, n.Dir("objects")+"/"+name, env)
+			if err != nil {
+				os.Remove(encrypted)
+			}
+		}
+		if err != nil {
+			log.Println("文件写入失败")
+			w.WriteHeader(http.StatusInternalServerError)
+			return err
+		}
+	} else {
+		err = os.Rename(file, n.Dir("objects")+"/"+name)
+		if err != nil {
+			log.Println("文件写入失败")
+			w.WriteHeader(http.StatusInternalServerError)
+			return err
+		}
+	}
+	fn := n.Dir("objects") + "/" + name
+	locate.Of(n).Add(name, size)
+
 	log.Printf("文件写入成功: %s", fn)
-	return err
+	return nil
 }
 
+// get 读取完整对象或纠删码分片, 分片的对象名为 <hash>.<id>
 func get(w http.ResponseWriter, r *http.Request) error {
-	f, err := os.Open(os.Getenv("STORAGE_ROOT") + "/objects/" + strings.Split(r.URL.EscapedPath(), "/")[2])
+	object := strings.Split(r.URL.EscapedPath(), "/")[2]
+	id := types.WholeObject
+	if i := strings.LastIndex(object, "."); i != -1 {
+		var err error
+		id, err = strconv.Atoi(object[i+1:])
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return err
+		}
+		object = object[:i]
+	}
+	fn, ok := locate.Of(node.FromContext(r.Context())).Locate(object)[id]
+	if !ok {
+		log.Println("文件打开失败")
+		w.WriteHeader(http.StatusNotFound)
+		return fmt.Errorf("object %s not found", strings.Split(r.URL.EscapedPath(), "/")[2])
+	}
+	info, err := os.Stat(fn)
 	if err != nil {
 		log.Println("文件打开失败")
 		w.WriteHeader(http.StatusNotFound)
 		return err
 	}
-	defer f.Close()
-	_, err = io.Copy(w, f)
+	// 加密保存的文件在这里解密, 返回的内容与 Range 都以解密后的数据计算
+	f, err := keys.Open(fn)
 	if err != nil {
 		log.Println("文件读取失败")
 		w.WriteHeader(http.StatusInternalServerError)
 		return err
 	}
+	defer f.Close()
+	// 压缩保存的文件原样返回, 由接口服务解压, 压缩数据中的区间没有意义, 因此忽略 Range 返回整个文件
+	if _, compression, _ := utils.SplitFileName(filepath.Base(fn)); compression != "" {
+		w.Header().Set("Content-Encoding", compression)
+		r.Header.Del("Range")
+	}
+	// ServeContent 处理 Range 请求, 只读取请求的区间, 并按 ETag 处理 If-Match, If-None-Match 与 If-Range
+	w.Header().Set("ETag", fileETag(filepath.Base(fn)))
+	http.ServeContent(w, r, "", info.ModTime(), f)
 
-	log.Printf("文件读取成功: %s", f.Name())
+	log.Printf("文件读取成功: %s", fn)
 	return err
-}
\ No newline at end of file
+}
+
+// fileETag 对象文件的 ETag 为其内容的散列值: 完整对象为对象散列值, 纠删码分片为文件名中的分片散列值;
+// 压缩保存的文件原样返回压缩后的数据, ETag 为 <散列值>~<压缩算法>, 与未压缩的表示区分
+func fileETag(file string) string {
+	hash, compression, _ := utils.SplitFileName(file)
+	file = hash
+	if parts := strings.Split(file, "."); len(parts) == 3 {
+		hash = parts[2]
+	}
+	if h, err := url.PathUnescape(hash); err == nil {
+		hash = h
+	}
+	return `"` + utils.CompressedFileName(hash, compression) + `"`
+}
+
+// del 删除对象的完整文件及其所有纠删码分片
+func del(w http.ResponseWriter, r *http.Request) error {
+	object := strings.Split(r.URL.EscapedPath(), "/")[2]
+	idx := locate.Of(node.FromContext(r.Context()))
+	files := idx.Locate(object)
+	if len(files) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return fmt.Errorf("object %s not found", object)
+	}
+	for _, fn := range files {
+		err := os.Remove(fn)
+		if err != nil && !os.IsNotExist(err) {
+			log.Println("文件删除失败")
+			w.WriteHeader(http.StatusInternalServerError)
+			return err
+		}
+		keys.Remove(fn)
+		idx.Del(filepath.Base(fn))
+		log.Printf("文件删除成功: %s", fn)
+	}
+	return nil
+}
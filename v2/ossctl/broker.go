@@ -0,0 +1,454 @@
+package main
+
+import (
+	"context"
+	"dot/v2/rabbitmq"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// broker 管理 RabbitMQ 上的拓扑, 拓扑由 rabbitmq.LoadBrokerConfig 读取的配置生成:
+// 交换机 apiServers, dataServers 与死信交换机, 损坏报告队列, 死信队列, 以及每个节点的持久化队列
+func broker(args []string) int {
+	fs := flag.NewFlagSet("broker", flag.ExitOnError)
+	force := fs.Bool("force", false, "teardown: delete queues that are in use or not empty, and exchanges that are still bound; migrate: recreate queues that still have consumers")
+	if len(args) == 0 {
+		usage()
+		return 2
+	}
+	cmd := args[0]
+	fs.Parse(args[1:])
+	cfg, err := rabbitmq.LoadBrokerConfig()
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	server := os.Getenv("RABBITMQ_SERVER")
+	if server == "" {
+		server = "amqp://localhost:5672/"
+	}
+	conn, err := amqp.Dial(server)
+	if err != nil {
+		log.Printf("Failed to connect to RabbitMQ: %v", err)
+		return 1
+	}
+	defer conn.Close()
+
+	t := cfg.Topology()
+	switch cmd {
+	case "init":
+		err = initTopology(conn, t)
+	case "verify":
+		err = verifyTopology(conn, t, server)
+	case "teardown":
+		err = teardownTopology(conn, t, *force)
+	case "migrate":
+		err = migrateTopology(conn, t, *force)
+	default:
+		usage()
+		return 2
+	}
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	return 0
+}
+
+// withChannel 在新通道上执行 f, 声明失败时 RabbitMQ 会关闭通道, 因此每个操作使用单独的通道
+func withChannel(conn *amqp.Connection, f func(ch *amqp.Channel) error) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+	return f(ch)
+}
+
+func declareExchange(ch *amqp.Channel, e rabbitmq.Exchange) error {
+	return ch.ExchangeDeclare(e.Name, e.Kind, true, false, false, false, nil)
+}
+
+func declareQueue(ch *amqp.Channel, q rabbitmq.Queue) (amqp.Queue, error) {
+	return ch.QueueDeclare(q.Name, true, false, false, false, q.Args)
+}
+
+// isMismatch 是否为已存在的交换机或队列的参数与声明不一致的错误
+func isMismatch(err error) bool {
+	var e *amqp.Error
+	return errors.As(err, &e) && e.Code == amqp.PreconditionFailed
+}
+
+// initTopology 声明所有交换机, 队列与绑定, 已存在且参数一致的不受影响, 可重复执行
+//
+// 已存在但参数不同的队列(如升级前不带参数声明的 scrubReports)保留原样并给出警告,
+// 服务进程仍可使用, 由 ossctl broker migrate 按配置重建
+func initTopology(conn *amqp.Connection, t rabbitmq.Topology) error {
+	for _, e := range t.Exchanges {
+		err := withChannel(conn, func(ch *amqp.Channel) error {
+			return declareExchange(ch, e)
+		})
+		if isMismatch(err) {
+			err = fmt.Errorf("%w (exists with different settings, run ossctl broker teardown first)", err)
+		}
+		if err != nil {
+			return fmt.Errorf("declare exchange %s: %w", e.Name, err)
+		}
+		fmt.Printf("exchange %s declared\n", e.Name)
+	}
+	kept := 0
+	for _, q := range t.Queues {
+		err := withChannel(conn, func(ch *amqp.Channel) error {
+			_, err := declareQueue(ch, q)
+			return err
+		})
+		if isMismatch(err) {
+			kept++
+			fmt.Printf("WARN queue %s exists with arguments different from %v, kept as is\n", q.Name, q.Args)
+		} else if err != nil {
+			return fmt.Errorf("declare queue %s: %w", q.Name, err)
+		} else {
+			fmt.Printf("queue %s declared %v\n", q.Name, q.Args)
+		}
+		if err := bindQueue(conn, q); err != nil {
+			return err
+		}
+	}
+	if kept > 0 {
+		fmt.Printf("%d queues kept with old arguments, run ossctl broker migrate to recreate them\n", kept)
+	}
+	return nil
+}
+
+// bindQueue 将队列绑定到配置中的交换机
+func bindQueue(conn *amqp.Connection, q rabbitmq.Queue) error {
+	return withChannel(conn, func(ch *amqp.Channel) error {
+		for _, exchange := range q.Bindings {
+			if err := ch.QueueBind(q.Name, "", exchange, false, nil); err != nil {
+				return fmt.Errorf("bind queue %s to %s: %w", q.Name, exchange, err)
+			}
+		}
+		return nil
+	})
+}
+
+// verifyTopology 检查所有交换机与队列存在且参数与配置一致; 设置了 RABBITMQ_MANAGEMENT_URL 时
+// 还通过管理接口检查绑定, AMQP 协议本身无法查询绑定
+func verifyTopology(conn *amqp.Connection, t rabbitmq.Topology, server string) error {
+	failed := 0
+	report := func(kind, name, detail string, err error) {
+		if err != nil {
+			failed++
+			fmt.Printf("FAIL %s %s: %v\n", kind, name, err)
+		} else {
+			fmt.Printf("ok   %s %s%s\n", kind, name, detail)
+		}
+	}
+	for _, e := range t.Exchanges {
+		report("exchange", e.Name, "", verifyExchange(conn, e))
+	}
+	for _, q := range t.Queues {
+		detail, err := verifyQueue(conn, q)
+		report("queue", q.Name, detail, err)
+	}
+	management := os.Getenv("RABBITMQ_MANAGEMENT_URL")
+	vhost := "/"
+	if uri, err := amqp.ParseURI(server); err == nil {
+		vhost = uri.Vhost
+	}
+	for _, q := range t.Queues {
+		for _, exchange := range q.Bindings {
+			name := q.Name + " <- " + exchange
+			if management == "" {
+				fmt.Printf("skip binding %s: RABBITMQ_MANAGEMENT_URL not set\n", name)
+				continue
+			}
+			report("binding", name, "", verifyBinding(management, vhost, exchange, q.Name))
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d checks failed, run ossctl broker init to fix missing entities", failed)
+	}
+	return nil
+}
+
+// verifyExchange 先被动声明检查是否存在, 再以相同参数声明, 参数不一致时 RabbitMQ 拒绝声明且不做修改
+func verifyExchange(conn *amqp.Connection, e rabbitmq.Exchange) error {
+	err := withChannel(conn, func(ch *amqp.Channel) error {
+		return ch.ExchangeDeclarePassive(e.Name, e.Kind, true, false, false, false, nil)
+	})
+	if rabbitmq.IsNotFound(err) {
+		return errors.New("missing")
+	}
+	if err != nil {
+		return err
+	}
+	err = withChannel(conn, func(ch *amqp.Channel) error {
+		return declareExchange(ch, e)
+	})
+	if isMismatch(err) {
+		return fmt.Errorf("type or durability differs: %w", err)
+	}
+	return err
+}
+
+// verifyQueue 与 verifyExchange 相同, 另外返回队列中的消息数与消费者数
+func verifyQueue(conn *amqp.Connection, q rabbitmq.Queue) (string, error) {
+	var state amqp.Queue
+	err := withChannel(conn, func(ch *amqp.Channel) error {
+		var err error
+		state, err = ch.QueueDeclarePassive(q.Name, true, false, false, false, nil)
+		return err
+	})
+	if rabbitmq.IsNotFound(err) {
+		return "", errors.New("missing")
+	}
+	if err != nil {
+		return "", err
+	}
+	err = withChannel(conn, func(ch *amqp.Channel) error {
+		_, err := declareQueue(ch, q)
+		return err
+	})
+	if isMismatch(err) {
+		return "", fmt.Errorf("arguments differ from %v, run ossctl broker migrate: %w", q.Args, err)
+	}
+	return fmt.Sprintf(" (%d messages, %d consumers)", state.Messages, state.Consumers), err
+}
+
+// verifyBinding 通过管理接口 GET /api/bindings/<vhost>/e/<exchange>/q/<queue> 检查绑定是否存在
+func verifyBinding(management, vhost, exchange, queue string) error {
+	u := strings.TrimSuffix(management, "/") + "/api/bindings/" +
+		url.PathEscape(vhost) + "/e/" + url.PathEscape(exchange) + "/q/" + url.PathEscape(queue)
+	resp, err := http.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("management API returned %s", resp.Status)
+	}
+	var bindings []json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&bindings); err != nil {
+		return err
+	}
+	if len(bindings) == 0 {
+		return errors.New("missing")
+	}
+	return nil
+}
+
+// teardownTopology 删除所有队列与交换机; 不加 force 时跳过仍有消费者或消息的队列以及仍有绑定的交换机
+func teardownTopology(conn *amqp.Connection, t rabbitmq.Topology, force bool) error {
+	failed := 0
+	for _, q := range t.Queues {
+		var n int
+		err := withChannel(conn, func(ch *amqp.Channel) error {
+			var err error
+			n, err = ch.QueueDelete(q.Name, !force, !force, false)
+			return err
+		})
+		if err != nil {
+			failed++
+			fmt.Printf("FAIL queue %s: %v\n", q.Name, err)
+			continue
+		}
+		fmt.Printf("queue %s deleted, %d messages dropped\n", q.Name, n)
+	}
+	for _, e := range t.Exchanges {
+		err := withChannel(conn, func(ch *amqp.Channel) error {
+			return ch.ExchangeDelete(e.Name, !force, false)
+		})
+		if err != nil {
+			failed++
+			fmt.Printf("FAIL exchange %s: %v\n", e.Name, err)
+			continue
+		}
+		fmt.Printf("exchange %s deleted\n", e.Name)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d deletions failed, stop the servers or use -force", failed)
+	}
+	return nil
+}
+
+// migrateTopology 按配置重建参数不一致的队列并保留其中的消息, 不存在的队列直接创建;
+// 不加 force 时跳过仍有消费者的队列, 需先停止使用它的服务进程
+func migrateTopology(conn *amqp.Connection, t rabbitmq.Topology, force bool) error {
+	failed := 0
+	for _, q := range t.Queues {
+		err := withChannel(conn, func(ch *amqp.Channel) error {
+			_, err := declareQueue(ch, q)
+			return err
+		})
+		if isMismatch(err) {
+			err = recreateQueue(conn, q, force)
+		} else if err == nil {
+			// 队列可能是刚刚创建的, 或者之前的绑定被删除
+			err = bindQueue(conn, q)
+		}
+		n := 0
+		if err == nil {
+			// 上次迁移中途失败时消息留在临时队列中, 在这里移回
+			n, err = restoreMessages(conn, q)
+		}
+		if err != nil {
+			failed++
+			fmt.Printf("FAIL queue %s: %v\n", q.Name, err)
+			continue
+		}
+		fmt.Printf("queue %s up to date %v, %d messages restored\n", q.Name, q.Args, n)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d migrations failed, stop the servers using them or use -force", failed)
+	}
+	return nil
+}
+
+// migrationQueue 迁移期间暂存队列 name 中消息的临时队列
+func migrationQueue(name string) string {
+	return name + ".migrate"
+}
+
+// recreateQueue 先把队列中的消息移到临时队列, 删除队列后按配置重新声明并绑定;
+// 临时队列中的消息由 restoreMessages 移回, 中途失败时消息留在临时队列中, 再次执行会继续迁移
+func recreateQueue(conn *amqp.Connection, q rabbitmq.Queue, force bool) error {
+	var state amqp.Queue
+	err := withChannel(conn, func(ch *amqp.Channel) error {
+		var err error
+		state, err = ch.QueueDeclarePassive(q.Name, true, false, false, false, nil)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if state.Consumers > 0 && !force {
+		return fmt.Errorf("%d consumers", state.Consumers)
+	}
+	// 临时队列不带参数, 迁移期间消息不会过期或转入死信
+	tmp := migrationQueue(q.Name)
+	err = withChannel(conn, func(ch *amqp.Channel) error {
+		_, err := ch.QueueDeclare(tmp, true, false, false, false, nil)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	// 删除时队列必须为空, 迁移期间新到的消息再移动一次
+	for attempt := 0; ; attempt++ {
+		if _, err := moveMessages(conn, q.Name, tmp); err != nil {
+			return err
+		}
+		err = withChannel(conn, func(ch *amqp.Channel) error {
+			_, err := ch.QueueDelete(q.Name, false, true, false)
+			return err
+		})
+		if !isMismatch(err) || attempt == 2 {
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("delete old queue: %w", err)
+	}
+	err = withChannel(conn, func(ch *amqp.Channel) error {
+		_, err := declareQueue(ch, q)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return bindQueue(conn, q)
+}
+
+// restoreMessages 把临时队列中的消息移回队列 q 并删除临时队列, 返回移回的消息数
+func restoreMessages(conn *amqp.Connection, q rabbitmq.Queue) (int, error) {
+	tmp := migrationQueue(q.Name)
+	err := withChannel(conn, func(ch *amqp.Channel) error {
+		_, err := ch.QueueDeclarePassive(tmp, true, false, false, false, nil)
+		return err
+	})
+	if rabbitmq.IsNotFound(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	n, err := moveMessages(conn, tmp, q.Name)
+	if err != nil {
+		return n, err
+	}
+	err = withChannel(conn, func(ch *amqp.Channel) error {
+		_, err := ch.QueueDelete(tmp, false, true, false)
+		return err
+	})
+	return n, err
+}
+
+// moveMessages 逐条把队列 from 中的消息发布到队列 to, 确认发布成功后才从 from 中删除, 返回移动的消息数
+func moveMessages(conn *amqp.Connection, from, to string) (int, error) {
+	n := 0
+	err := withChannel(conn, func(ch *amqp.Channel) error {
+		if err := ch.Confirm(false); err != nil {
+			return err
+		}
+		// 队列 to 不存在时消息被退回, 退回先于确认到达
+		returns := ch.NotifyReturn(make(chan amqp.Return, 1))
+		for {
+			d, ok, err := ch.Get(from, false)
+			if err != nil || !ok {
+				return err
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			confirm, err := ch.PublishWithDeferredConfirmWithContext(ctx, "", to, true, false, amqp.Publishing{
+				Headers:         d.Headers,
+				ContentType:     d.ContentType,
+				ContentEncoding: d.ContentEncoding,
+				DeliveryMode:    amqp.Persistent,
+				Priority:        d.Priority,
+				CorrelationId:   d.CorrelationId,
+				ReplyTo:         d.ReplyTo,
+				Expiration:      d.Expiration,
+				MessageId:       d.MessageId,
+				Timestamp:       d.Timestamp,
+				Type:            d.Type,
+				UserId:          d.UserId,
+				AppId:           d.AppId,
+				Body:            d.Body,
+			})
+			if err == nil {
+				var acked bool
+				acked, err = confirm.WaitContext(ctx)
+				if err == nil && !acked {
+					err = fmt.Errorf("broker rejected message moved to %s", to)
+				}
+			}
+			cancel()
+			if err == nil {
+				select {
+				case r := <-returns:
+					err = fmt.Errorf("message returned by %s: %s", to, r.ReplyText)
+				default:
+				}
+			}
+			if err != nil {
+				d.Nack(false, true)
+				return err
+			}
+			if err := d.Ack(false); err != nil {
+				return err
+			}
+			n++
+		}
+	})
+	return n, err
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/joho/godotenv"
+)
+
+// ossctl 运维命令, 配置与服务进程相同, 从环境变量及 -env 指定的文件读取
+//
+//	ossctl [-env 文件] broker init|verify|migrate|teardown [-force]
+func main() {
+	env := flag.String("env", ".env", "environment file")
+	flag.Usage = usage
+	flag.Parse()
+	if err := godotenv.Load(*env); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	args := flag.Args()
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
+	}
+	switch args[0] {
+	case "broker":
+		os.Exit(broker(args[1:]))
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `Usage: ossctl [-env file] <command>
+
+Commands:
+  broker init              declare exchanges, dead-letter exchange and durable queues
+  broker verify            check that the running broker matches the expected topology
+  broker migrate [-force]  recreate queues whose arguments differ from the configuration, keeping their messages;
+                           -force also recreates queues that still have consumers
+  broker teardown [-force] delete the topology; -force also deletes queues in use or not empty
+
+Flags:
+`)
+	flag.PrintDefaults()
+}
@@ -0,0 +1,95 @@
+package rabbitmq
+
+import (
+	"context"
+	"os"
+	"sync"
+)
+
+// Message 收到的一条消息
+type Message struct {
+	Body    []byte // 消息体, 为发送时 body 的 JSON 编码
+	ReplyTo string // 发送者的队列名, 可用 Reply 回复
+	// CorrelationId 请求的关联 id, 回复中带有同样的 id, 使多个并发的请求可以共用一个回复队列
+	CorrelationId string
+}
+
+// Bus 接口服务与数据服务之间的消息通道, 每个 Bus 对应一个队列;
+// 默认实现为 RabbitMQ 的 Client, Memory 为进程内的实现
+type Bus interface {
+	// Name 当前的队列名
+	Name() string
+	// Bind 将队列绑定到 fanout 交换机, 之后该交换机上广播的消息都会投递到队列
+	Bind(ctx context.Context, exchange string) error
+	// Publish 向 fanout 交换机广播消息, 消息体为 body 的 JSON 编码, ReplyTo 为当前的队列名
+	Publish(ctx context.Context, exchange string, body any) error
+	// Request 与 Publish 相同, 消息带有关联 id, 接收方以 Reply 回复
+	Request(ctx context.Context, exchange, correlationId string, body any) error
+	// Send 直接向队列发送消息, ReplyTo 为当前的队列名
+	Send(ctx context.Context, queue string, body any) error
+	// Reply 回复消息 msg: 发送到其 ReplyTo 队列, 并带上其关联 id
+	Reply(ctx context.Context, msg Message, body any) error
+	// Consume 消费队列中的消息, 返回的通道在 ctx 取消或 Bus 关闭时关闭
+	Consume(ctx context.Context) (<-chan Message, error)
+	// Close 关闭 Bus, 匿名队列随之删除
+	Close()
+}
+
+// Transport 按队列名创建 Bus, queue 为空时创建匿名的临时队列, 否则使用持久的具名队列,
+// 多个消费者共同消费同一个具名队列时每条消息只被处理一次
+type Transport interface {
+	// Open 创建 Bus, 消息服务不可用时立即返回错误
+	Open(ctx context.Context, queue string) (Bus, error)
+	// Dial 与 Open 相同, 但消息服务不可用时一直重试, 只在 ctx 被取消时返回错误
+	Dial(ctx context.Context, queue string) (Bus, error)
+}
+
+// AMQP 连接 RabbitMQ 服务器 URL 的 Transport
+type AMQP struct {
+	URL string
+}
+
+func (a AMQP) Open(ctx context.Context, queue string) (Bus, error) {
+	c, err := New(ctx, a.URL, queue)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (a AMQP) Dial(ctx context.Context, queue string) (Bus, error) {
+	c, err := Connect(ctx, a.URL, queue)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+var (
+	transport     Transport
+	transportOnce sync.Once
+)
+
+// SetTransport 替换默认的消息传输, 需在启动服务之前调用, 如以 NewMemory 在一个进程中运行接口服务与数据服务
+func SetTransport(t Transport) {
+	transportOnce.Do(func() {})
+	transport = t
+}
+
+// DefaultTransport 返回消息传输, 默认连接 RABBITMQ_SERVER 指定的 RabbitMQ
+func DefaultTransport() Transport {
+	transportOnce.Do(func() {
+		transport = AMQP{URL: os.Getenv("RABBITMQ_SERVER")}
+	})
+	return transport
+}
+
+// Open 以默认的消息传输创建 Bus, 见 Transport.Open
+func Open(ctx context.Context, queue string) (Bus, error) {
+	return DefaultTransport().Open(ctx, queue)
+}
+
+// Dial 以默认的消息传输创建 Bus, 见 Transport.Dial
+func Dial(ctx context.Context, queue string) (Bus, error) {
+	return DefaultTransport().Dial(ctx, queue)
+}
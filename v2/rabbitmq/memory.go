@@ -0,0 +1,220 @@
+package rabbitmq
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// Memory 进程内的 Transport, 行为与 RabbitMQ 的 fanout 交换机及队列一致:
+// 广播的消息投递到绑定交换机的每个队列, 具名队列在 Bus 关闭后保留消息, 匿名队列随 Bus 关闭删除,
+// 没有队列接收的消息被丢弃. 用于在一个进程(如 go test)中运行接口服务与多个数据服务
+type Memory struct {
+	mutex     sync.Mutex
+	queues    map[string]*memQueue
+	exchanges map[string]map[*memQueue]bool // 交换机 -> 绑定的队列
+	seq       int                           // 匿名队列的序号
+}
+
+// NewMemory 创建进程内的消息传输, 同一个 Memory 创建的 Bus 之间才能互相通信
+func NewMemory() *Memory {
+	return &Memory{queues: make(map[string]*memQueue), exchanges: make(map[string]map[*memQueue]bool)}
+}
+
+func (m *Memory) Open(ctx context.Context, queue string) (Bus, error) {
+	if ctx.Err() != nil {
+		return nil, ErrClosed
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	q := m.queues[queue]
+	if q == nil {
+		name := queue
+		if name == "" {
+			m.seq++
+			name = fmt.Sprintf("amq.gen-%d", m.seq)
+		}
+		q = &memQueue{name: name, signal: make(chan struct{}, 1)}
+		m.queues[name] = q
+	}
+	b := &memBus{m: m, q: q, anonymous: queue == ""}
+	b.ctx, b.cancel = context.WithCancel(ctx)
+	go func() {
+		<-b.ctx.Done()
+		b.release()
+	}()
+	return b, nil
+}
+
+// Dial 与 Open 相同, 进程内的消息传输总是可用
+func (m *Memory) Dial(ctx context.Context, queue string) (Bus, error) {
+	return m.Open(ctx, queue)
+}
+
+// route 将消息投递到交换机绑定的所有队列, exchange 为空时直接投递到名为 key 的队列
+func (m *Memory) route(exchange, key string, msg Message) {
+	m.mutex.Lock()
+	var targets []*memQueue
+	if exchange == "" {
+		if q := m.queues[key]; q != nil {
+			targets = append(targets, q)
+		}
+	} else {
+		for q := range m.exchanges[exchange] {
+			targets = append(targets, q)
+		}
+	}
+	m.mutex.Unlock()
+	for _, q := range targets {
+		q.push(msg)
+	}
+}
+
+// memQueue 不限长度的消息队列, 多个消费者竞争消费
+type memQueue struct {
+	name   string
+	mutex  sync.Mutex
+	msgs   []Message
+	signal chan struct{} // 队列非空时有信号
+}
+
+func (q *memQueue) push(msg Message) {
+	q.mutex.Lock()
+	q.msgs = append(q.msgs, msg)
+	q.mutex.Unlock()
+	q.notify()
+}
+
+func (q *memQueue) notify() {
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+}
+
+// pop 取出队首的消息, 队列为空时等待, ctx 或 done 结束时返回 false
+func (q *memQueue) pop(ctx context.Context, done <-chan struct{}) (Message, bool) {
+	for {
+		q.mutex.Lock()
+		if len(q.msgs) > 0 {
+			msg := q.msgs[0]
+			q.msgs[0] = Message{}
+			q.msgs = q.msgs[1:]
+			more := len(q.msgs) > 0
+			q.mutex.Unlock()
+			if more {
+				// 唤醒其它消费者
+				q.notify()
+			}
+			return msg, true
+		}
+		q.mutex.Unlock()
+		select {
+		case <-q.signal:
+		case <-ctx.Done():
+			return Message{}, false
+		case <-done:
+			return Message{}, false
+		}
+	}
+}
+
+type memBus struct {
+	m         *Memory
+	q         *memQueue
+	anonymous bool
+	ctx       context.Context
+	cancel    context.CancelFunc
+}
+
+func (b *memBus) Name() string {
+	return b.q.name
+}
+
+func (b *memBus) Bind(ctx context.Context, exchange string) error {
+	if b.ctx.Err() != nil {
+		return ErrClosed
+	}
+	b.m.mutex.Lock()
+	defer b.m.mutex.Unlock()
+	if b.m.exchanges[exchange] == nil {
+		b.m.exchanges[exchange] = make(map[*memQueue]bool)
+	}
+	b.m.exchanges[exchange][b.q] = true
+	return nil
+}
+
+func (b *memBus) Publish(ctx context.Context, exchange string, body any) error {
+	return b.publish(ctx, exchange, "", "", body)
+}
+
+func (b *memBus) Request(ctx context.Context, exchange, correlationId string, body any) error {
+	return b.publish(ctx, exchange, "", correlationId, body)
+}
+
+func (b *memBus) Send(ctx context.Context, queue string, body any) error {
+	return b.publish(ctx, "", queue, "", body)
+}
+
+func (b *memBus) Reply(ctx context.Context, msg Message, body any) error {
+	return b.publish(ctx, "", msg.ReplyTo, msg.CorrelationId, body)
+}
+
+func (b *memBus) publish(ctx context.Context, exchange, key, correlationId string, body any) error {
+	if b.ctx.Err() != nil {
+		return ErrClosed
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	msg, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	b.m.route(exchange, key, Message{Body: msg, ReplyTo: b.q.name, CorrelationId: correlationId})
+	return nil
+}
+
+func (b *memBus) Consume(ctx context.Context) (<-chan Message, error) {
+	if b.ctx.Err() != nil {
+		return nil, ErrClosed
+	}
+	out := make(chan Message)
+	go func() {
+		defer close(out)
+		for {
+			msg, ok := b.q.pop(ctx, b.ctx.Done())
+			if !ok {
+				return
+			}
+			// 与自动确认一致, 取出后未送达的消息丢失
+			select {
+			case out <- msg:
+			case <-ctx.Done():
+				return
+			case <-b.ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+func (b *memBus) Close() {
+	b.cancel()
+	b.release()
+}
+
+// release 删除匿名队列及其绑定, 具名队列保留
+func (b *memBus) release() {
+	if !b.anonymous {
+		return
+	}
+	b.m.mutex.Lock()
+	defer b.m.mutex.Unlock()
+	delete(b.m.queues, b.q.name)
+	for _, queues := range b.m.exchanges {
+		delete(queues, b.q)
+	}
+}
@@ -1,189 +1,383 @@
 package rabbitmq
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"slices"
+	"sync"
+	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
-// RabbitMQ 结构体
-type RabbitMQ struct {
-	conn     *amqp.Connection
-	channel  *amqp.Channel
-	Name     string
-	exchange string
+// ErrClosed 客户端已关闭或其 context 已取消
+var ErrClosed = errors.New("rabbitmq: client closed")
+
+// 断线重连的退避时间, 从 minBackoff 开始每次失败加倍, 最多 maxBackoff
+const (
+	minBackoff = 500 * time.Millisecond
+	maxBackoff = 30 * time.Second
+)
+
+// Client 自动重连的 RabbitMQ 客户端
+//
+// 连接或通道被关闭(NotifyClose)后按退避时间重连, 重连后重新声明队列与绑定;
+// 断线期间 Publish, Send, Bind 等待重连完成或 ctx 取消, Consume 返回的通道在重连后继续投递消息.
+// 匿名队列在重连后名字会改变, 发出的消息的 ReplyTo 总是当前的队列名
+type Client struct {
+	url   string
+	queue string // 声明的队列名, 为空表示匿名的临时队列
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mutex     sync.Mutex
+	conn      *amqp.Connection
+	channel   *amqp.Channel
+	name      string          // 当前的队列名
+	bindings  []string        // 队列绑定的交换机, 重连后重新绑定
+	exchanges map[string]bool // 当前连接上已声明的交换机
+	gen       int             // 连接的代数, 每次重连成功后加一, 为 0 表示尚未连接
+	ready     chan struct{}   // 连接成功时关闭, 等待者借此得知代数变化
 }
 
-// New 创建一个 RabbitMQ 对象
-func New(s string) *RabbitMQ {
-	mq := &RabbitMQ{}
-	err := mq.connect(s)
-	if err != nil {
-		panic(err)
+// New 连接 RabbitMQ 并声明队列, queue 为空时创建匿名的临时队列, 否则声明持久化的具名队列,
+// 多个消费者共同消费同一个具名队列时每条消息只被处理一次; 首次连接失败时返回错误,
+// 之后断线自动重连, 直到调用 Close 或 ctx 被取消
+func New(ctx context.Context, url, queue string) (*Client, error) {
+	c := newClient(ctx, url, queue)
+	if err := c.connect(); err != nil {
+		c.cancel()
+		return nil, err
 	}
-	return mq
+	go c.watch()
+	return c, nil
 }
 
-// connect 建立连接
-func (q *RabbitMQ) connect(s string) error {
-	// 创建连接
-	conn, err := amqp.Dial(s)
-	if err != nil {
-		return err
+// Connect 与 New 相同, 但首次连接失败时同样按退避时间重试, 只在 ctx 被取消时返回错误,
+// 用于在 RabbitMQ 之后启动也能正常工作的常驻服务
+func Connect(ctx context.Context, url, queue string) (*Client, error) {
+	c := newClient(ctx, url, queue)
+	if err := c.reconnect(); err != nil {
+		c.cancel()
+		return nil, err
 	}
+	go c.watch()
+	return c, nil
+}
 
-	// 创建通道
-	ch, err := conn.Channel()
+func newClient(ctx context.Context, url, queue string) *Client {
+	c := &Client{url: url, queue: queue, ready: make(chan struct{})}
+	c.ctx, c.cancel = context.WithCancel(ctx)
+	return c
+}
+
+// connect 建立连接与通道, 声明队列并恢复绑定
+func (c *Client) connect() error {
+	conn, err := amqp.DialConfig(c.url, amqp.Config{
+		Heartbeat: 10 * time.Second,
+		Locale:    "en_US",
+		Dial: func(network, addr string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(c.ctx, network, addr)
+		},
+	})
 	if err != nil {
-		conn.Close()
 		return err
 	}
-
-	// 创建队列
-	queue, err := ch.QueueDeclare(
-		"",      // name
-		false,   // durable
-		true,    // autoDelete
-		false,   // exclusive
-		false,   // noWait
-		nil,     // args
-	)
+	ch, queue, err := declareQueue(conn, c.queue)
 	if err != nil {
-		ch.Close()
 		conn.Close()
 		return err
 	}
+	exchanges := make(map[string]bool)
+	c.mutex.Lock()
+	bindings := append([]string(nil), c.bindings...)
+	c.mutex.Unlock()
+	for _, exchange := range bindings {
+		if err := bind(ch, queue.Name, exchange); err != nil {
+			conn.Close()
+			return err
+		}
+		exchanges[exchange] = true
+	}
 
-	q.conn = conn
-	q.channel = ch
-	q.Name = queue.Name
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.ctx.Err() != nil {
+		conn.Close()
+		return ErrClosed
+	}
+	c.conn, c.channel, c.name, c.exchanges = conn, ch, queue.Name, exchanges
+	c.gen++
+	close(c.ready)
+	c.ready = make(chan struct{})
 	return nil
 }
 
-// isConnected 检查连接是否有效
-func (q *RabbitMQ) isConnected() bool {
-	return q.conn != nil && !q.conn.IsClosed() && q.channel != nil
+// reconnect 按退避时间重试 connect, 直到成功或 ctx 被取消
+func (c *Client) reconnect() error {
+	backoff := minBackoff
+	for {
+		err := c.connect()
+		if err == nil {
+			return nil
+		}
+		if c.ctx.Err() != nil {
+			return ErrClosed
+		}
+		log.Printf("Failed to connect to RabbitMQ, retry in %v: %v", backoff, err)
+		select {
+		case <-c.ctx.Done():
+			return ErrClosed
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
 }
 
-// Bind 绑定交换机
-func (q *RabbitMQ) Bind (exchange string) {
-	// 尝试绑定队列到交换机
-	// 注意：这里假设交换机已经存在，如果不存在会返回错误
-	err := q.channel.QueueBind(
-		q.Name, "", exchange, false, nil,
-	)
-	if err != nil {
-		// 如果交换机不存在，尝试创建它（仅用于开发环境）
-		if amqpErr, ok := err.(*amqp.Error); ok && amqpErr.Code == 404 {
-			// 声明交换机
-			declareErr := q.channel.ExchangeDeclare(
-				exchange, // name
-				"fanout", // type
-				true,     // durable
-				false,    // auto-deleted
-				false,    // internal
-				false,    // no-wait
-				nil,      // arguments
-			)
-			if declareErr != nil {
-				panic(declareErr)
-			}
-
-			// 重新尝试绑定
-			err = q.channel.QueueBind(
-				q.Name, "", exchange, false, nil,
-			)
+// watch 在连接或通道被关闭后重连, ctx 取消时关闭连接
+func (c *Client) watch() {
+	for {
+		c.mutex.Lock()
+		conn, ch := c.conn, c.channel
+		c.mutex.Unlock()
+		connClosed := conn.NotifyClose(make(chan *amqp.Error, 1))
+		chClosed := ch.NotifyClose(make(chan *amqp.Error, 1))
+		select {
+		case <-c.ctx.Done():
+			conn.Close()
+			return
+		case err := <-connClosed:
+			log.Printf("RabbitMQ connection closed: %v", err)
+		case err := <-chClosed:
+			log.Printf("RabbitMQ channel closed: %v", err)
+		}
+		conn.Close()
+		c.mutex.Lock()
+		c.channel = nil
+		c.mutex.Unlock()
+		if c.reconnect() != nil {
+			return
 		}
+		log.Printf("Reconnected to RabbitMQ, queue %s", c.Name())
+	}
+}
 
-		if err != nil {
-			panic(err)
+// wait 等待代数大于 after 的连接可用, 返回其通道, 队列名与代数
+func (c *Client) wait(ctx context.Context, after int) (*amqp.Channel, string, int, error) {
+	for {
+		c.mutex.Lock()
+		ch, name, gen, ready := c.channel, c.name, c.gen, c.ready
+		c.mutex.Unlock()
+		if c.ctx.Err() != nil {
+			return nil, "", 0, ErrClosed
+		}
+		if ch != nil && gen > after {
+			return ch, name, gen, nil
+		}
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return nil, "", 0, ctx.Err()
+		case <-c.ctx.Done():
+			return nil, "", 0, ErrClosed
 		}
 	}
-	q.exchange = exchange
 }
 
-func (q *RabbitMQ) Send(queue string, body any) {
-	s, err := json.Marshal(body)
-	if err != nil {
-		panic(err)
-	}
-	// match the binding routing key as ""
-	err = q.channel.Publish(
-		"",queue, false, false,
-		amqp.Publishing{
-			ReplyTo: q.Name,
-			Body: []byte(s),
-		},
-	)
+// Name 当前的队列名
+func (c *Client) Name() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.name
+}
+
+// Bind 将队列绑定到 fanout 交换机, 交换机不存在时创建; 绑定在重连后自动恢复
+func (c *Client) Bind(ctx context.Context, exchange string) error {
+	c.mutex.Lock()
+	if !slices.Contains(c.bindings, exchange) {
+		c.bindings = append(c.bindings, exchange)
+	}
+	c.mutex.Unlock()
+	ch, name, _, err := c.wait(ctx, 0)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	if err := bind(ch, name, exchange); err != nil {
+		return err
 	}
+	c.declared(ch, exchange)
+	return nil
 }
 
-func (q *RabbitMQ) Publish(exchange string, body any) {
-	s, err := json.Marshal(body)
+// declareQueue 在新通道上声明队列: 匿名队列随连接删除; 具名队列已存在时直接使用,
+// 不存在时按拓扑配置的参数创建, BROKER_DECLARE=passive 时不创建而返回错误
+func declareQueue(conn *amqp.Connection, name string) (*amqp.Channel, amqp.Queue, error) {
+	ch, err := conn.Channel()
 	if err != nil {
-		panic(err)
+		return nil, amqp.Queue{}, err
+	}
+	if name == "" {
+		queue, err := ch.QueueDeclare(
+			"",    // name
+			false, // durable
+			true,  // autoDelete
+			false, // exclusive
+			false, // noWait
+			nil,   // args
+		)
+		return ch, queue, err
 	}
+	queue, err := ch.QueueDeclarePassive(name, true, false, false, false, nil)
+	if err == nil || !IsNotFound(err) || DefaultBrokerConfig().Passive {
+		return ch, queue, err
+	}
+	// 被动声明失败后通道已被关闭
+	if ch, err = conn.Channel(); err != nil {
+		return nil, amqp.Queue{}, err
+	}
+	queue, err = ch.QueueDeclare(name, true, false, false, false, DefaultBrokerConfig().QueueArgs(name))
+	return ch, queue, err
+}
 
-	// 尝试发布消息
-	err = q.channel.Publish(
-		exchange, "", false, false,
-		amqp.Publishing{
-			ReplyTo: q.Name,
-			Body: []byte(s),
-		},
+// IsNotFound 是否为交换机或队列不存在的错误
+func IsNotFound(err error) bool {
+	var e *amqp.Error
+	return errors.As(err, &e) && e.Code == amqp.NotFound
+}
+
+func bind(ch *amqp.Channel, queue, exchange string) error {
+	if err := declareExchange(ch, exchange); err != nil {
+		return err
+	}
+	return ch.QueueBind(queue, "", exchange, false, nil)
+}
+
+// declareExchange 声明持久化的 fanout 交换机, 与 ossctl broker init 创建的交换机参数一致;
+// BROKER_DECLARE=passive 时只检查交换机是否存在
+func declareExchange(ch *amqp.Channel, exchange string) error {
+	if DefaultBrokerConfig().Passive {
+		return ch.ExchangeDeclarePassive(exchange, amqp.ExchangeFanout, true, false, false, false, nil)
+	}
+	return ch.ExchangeDeclare(
+		exchange, // name
+		"fanout", // type
+		true,     // durable
+		false,    // auto-deleted
+		false,    // internal
+		false,    // no-wait
+		nil,      // arguments
 	)
+}
 
-	// 如果交换机不存在，尝试创建它（仅用于开发环境）
-	if err != nil {
-		if amqpErr, ok := err.(*amqp.Error); ok && amqpErr.Code == 404 {
-			// 声明交换机
-			declareErr := q.channel.ExchangeDeclare(
-				exchange, // name
-				"fanout", // type
-				true,     // durable
-				false,    // auto-deleted
-				false,    // internal
-				false,    // no-wait
-				nil,      // arguments
-			)
-			if declareErr != nil {
-				panic(declareErr)
-			}
+// declared 记录通道 ch 上已声明的交换机, 通道已被重连替换时忽略
+func (c *Client) declared(ch *amqp.Channel, exchange string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.channel == ch {
+		c.exchanges[exchange] = true
+	}
+}
 
-			// 重新尝试发布
-			err = q.channel.Publish(
-				exchange, "", false, false,
-				amqp.Publishing{
-					ReplyTo: q.Name,
-					Body: []byte(s),
-				},
-			)
-		}
+// Publish 向 fanout 交换机广播消息, 消息体为 body 的 JSON 编码, 交换机不存在时创建
+func (c *Client) Publish(ctx context.Context, exchange string, body any) error {
+	return c.Request(ctx, exchange, "", body)
+}
 
-		if err != nil {
-			panic(err)
+// Request 与 Publish 相同, 消息带有关联 id, 接收方以 Reply 回复
+func (c *Client) Request(ctx context.Context, exchange, correlationId string, body any) error {
+	ch, _, _, err := c.wait(ctx, 0)
+	if err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	declared := c.channel == ch && c.exchanges[exchange]
+	c.mutex.Unlock()
+	if !declared {
+		if err := declareExchange(ch, exchange); err != nil {
+			return err
 		}
+		c.declared(ch, exchange)
 	}
+	return c.publish(ctx, exchange, "", correlationId, body)
 }
 
-func (q * RabbitMQ) Consume() <-chan amqp.Delivery {
-	c, err := q.channel.Consume(
-		q.Name, "", true, false, false, false, nil,
-	)
+// Send 直接向队列发送消息
+func (c *Client) Send(ctx context.Context, queue string, body any) error {
+	return c.publish(ctx, "", queue, "", body)
+}
+
+// Reply 回复消息 msg: 发送到其 ReplyTo 队列, 并带上其关联 id
+func (c *Client) Reply(ctx context.Context, msg Message, body any) error {
+	return c.publish(ctx, "", msg.ReplyTo, msg.CorrelationId, body)
+}
+
+func (c *Client) publish(ctx context.Context, exchange, key, correlationId string, body any) error {
+	b, err := json.Marshal(body)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	return c
+	ch, name, _, err := c.wait(ctx, 0)
+	if err != nil {
+		return err
+	}
+	return ch.PublishWithContext(ctx, exchange, key, false, false, amqp.Publishing{
+		ReplyTo:       name,
+		CorrelationId: correlationId,
+		Body:          b,
+	})
 }
 
-// Close 关闭通道和连接
-func (q *RabbitMQ) Close() {
-	if q.channel != nil {
-		q.channel.Close()
+// Consume 消费队列中的消息(自动确认), 返回的通道在断线重连后继续投递, ctx 取消或客户端关闭时关闭
+func (c *Client) Consume(ctx context.Context) (<-chan Message, error) {
+	ch, name, gen, err := c.wait(ctx, 0)
+	if err != nil {
+		return nil, err
 	}
-	if q.conn != nil {
-		q.conn.Close()
+	deliveries, err := ch.Consume(name, "", true, false, false, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan Message)
+	go func() {
+		defer close(out)
+		for {
+			for d := range deliveries {
+				select {
+				case out <- Message{Body: d.Body, ReplyTo: d.ReplyTo, CorrelationId: d.CorrelationId}:
+				case <-ctx.Done():
+					return
+				case <-c.ctx.Done():
+					return
+				}
+			}
+			// 连接断开, 等待重连后在新的通道上继续消费
+			for {
+				ch, name, gen, err = c.wait(ctx, gen)
+				if err != nil {
+					return
+				}
+				deliveries, err = ch.Consume(name, "", true, false, false, false, nil)
+				if err == nil {
+					break
+				}
+				log.Printf("Failed to consume queue %s: %v", name, err)
+			}
+		}
+	}()
+	return out, nil
+}
+
+// Close 关闭连接, 不再重连, 之后的调用都返回 ErrClosed
+func (c *Client) Close() {
+	c.cancel()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.conn != nil {
+		c.conn.Close()
 	}
-}
\ No newline at end of file
+}
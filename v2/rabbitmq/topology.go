@@ -0,0 +1,189 @@
+package rabbitmq
+
+import (
+	"dot/v2/types"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// 接口服务与数据服务使用的 fanout 交换机
+const (
+	ApiServersExchange  = "apiServers"  // 数据服务广播心跳
+	DataServersExchange = "dataServers" // 接口服务广播定位请求
+)
+
+// BrokerConfig 消息服务的拓扑配置, 由 ossctl broker 与服务进程共用, 保证声明的参数一致
+type BrokerConfig struct {
+	// DeadLetterExchange 死信交换机, 损坏报告队列中被拒绝的消息转到这里
+	DeadLetterExchange string
+	DeadLetterTTL      time.Duration // 死信队列中消息的存活时间
+	MessageTTL         time.Duration // 节点队列中消息的存活时间, 心跳与定位请求过期后已无意义
+	// QueueType 具名队列的类型, classic 或 quorum; quorum 队列在集群的多个节点上复制, 单个节点故障时不丢失
+	QueueType string
+	ApiNodes  []string // 接口服务的节点 id, 每个节点一个持久化的心跳队列
+	DataNodes []string // 数据服务的节点 id, 每个节点一个持久化的定位请求队列
+	// NodeQueues 服务进程是否使用本节点的持久化队列, 否则使用随连接删除的匿名队列
+	NodeQueues bool
+	// Passive 服务进程只检查交换机与具名队列是否存在而不声明, 拓扑由 ossctl broker init 创建
+	Passive bool
+}
+
+// LoadBrokerConfig 从环境变量读取拓扑配置:
+//
+//	BROKER_DEAD_LETTER_EXCHANGE 死信交换机与死信队列名, 默认 deadLetters
+//	BROKER_DEAD_LETTER_TTL      死信的存活时间, 默认 24h
+//	BROKER_MESSAGE_TTL          节点队列中消息的存活时间, 默认 10s
+//	BROKER_QUEUE_TYPE           classic(默认) 或 quorum
+//	BROKER_API_NODES            逗号分隔的接口服务节点 id
+//	BROKER_DATA_NODES           逗号分隔的数据服务节点 id
+//	BROKER_NODE_QUEUES          为 true 时服务进程使用 NODE_ID 对应的节点队列
+//	BROKER_DECLARE              auto(默认) 时服务进程自动声明拓扑, passive 时只检查
+func LoadBrokerConfig() (BrokerConfig, error) {
+	cfg := BrokerConfig{
+		DeadLetterExchange: os.Getenv("BROKER_DEAD_LETTER_EXCHANGE"),
+		QueueType:          os.Getenv("BROKER_QUEUE_TYPE"),
+		ApiNodes:           splitList(os.Getenv("BROKER_API_NODES")),
+		DataNodes:          splitList(os.Getenv("BROKER_DATA_NODES")),
+		NodeQueues:         os.Getenv("BROKER_NODE_QUEUES") == "true",
+	}
+	if cfg.DeadLetterExchange == "" {
+		cfg.DeadLetterExchange = "deadLetters"
+	}
+	var err error
+	if cfg.DeadLetterTTL, err = parseTTL("BROKER_DEAD_LETTER_TTL", 24*time.Hour); err != nil {
+		return cfg, err
+	}
+	if cfg.MessageTTL, err = parseTTL("BROKER_MESSAGE_TTL", 10*time.Second); err != nil {
+		return cfg, err
+	}
+	switch cfg.QueueType {
+	case "":
+		cfg.QueueType = amqp.QueueTypeClassic
+	case amqp.QueueTypeClassic, amqp.QueueTypeQuorum:
+	default:
+		return cfg, fmt.Errorf("invalid BROKER_QUEUE_TYPE %q, must be classic or quorum", cfg.QueueType)
+	}
+	switch d := os.Getenv("BROKER_DECLARE"); d {
+	case "", "auto":
+	case "passive":
+		cfg.Passive = true
+	default:
+		return cfg, fmt.Errorf("invalid BROKER_DECLARE %q, must be auto or passive", d)
+	}
+	return cfg, nil
+}
+
+func parseTTL(name string, def time.Duration) (time.Duration, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	ttl, err := time.ParseDuration(v)
+	if err != nil || ttl < time.Millisecond {
+		return 0, fmt.Errorf("invalid %s %q", name, v)
+	}
+	return ttl, nil
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+var (
+	brokerConfig     BrokerConfig
+	brokerConfigOnce sync.Once
+)
+
+// DefaultBrokerConfig 服务进程使用的拓扑配置, 配置错误时退出
+func DefaultBrokerConfig() BrokerConfig {
+	brokerConfigOnce.Do(func() {
+		cfg, err := LoadBrokerConfig()
+		if err != nil {
+			log.Fatal(err)
+		}
+		brokerConfig = cfg
+	})
+	return brokerConfig
+}
+
+// NodeQueueName 节点 node 在交换机 exchange 上的持久化队列名
+func NodeQueueName(exchange, node string) string {
+	return exchange + "." + node
+}
+
+// NodeQueue 本节点 node 接收交换机 exchange 广播的队列名, 未启用 BROKER_NODE_QUEUES 时为空, 即使用匿名队列
+func NodeQueue(exchange, node string) string {
+	if !DefaultBrokerConfig().NodeQueues || node == "" {
+		return ""
+	}
+	return NodeQueueName(exchange, node)
+}
+
+// Exchange 一个 fanout 交换机
+type Exchange struct {
+	Name string
+	Kind string
+}
+
+// Queue 一个持久化的具名队列及其绑定的交换机
+type Queue struct {
+	Name     string
+	Bindings []string
+	Args     amqp.Table
+}
+
+// Topology 消息服务上需要预先创建的交换机与队列, 匿名队列由服务进程自行创建
+type Topology struct {
+	Exchanges []Exchange
+	Queues    []Queue
+}
+
+// Topology 按配置生成完整的拓扑
+func (cfg BrokerConfig) Topology() Topology {
+	t := Topology{Exchanges: []Exchange{
+		{ApiServersExchange, amqp.ExchangeFanout},
+		{DataServersExchange, amqp.ExchangeFanout},
+		{cfg.DeadLetterExchange, amqp.ExchangeFanout},
+	}}
+	t.Queues = append(t.Queues,
+		Queue{types.ScrubQueue, nil, cfg.QueueArgs(types.ScrubQueue)},
+		Queue{cfg.DeadLetterExchange, []string{cfg.DeadLetterExchange}, cfg.QueueArgs(cfg.DeadLetterExchange)},
+	)
+	for _, node := range cfg.ApiNodes {
+		name := NodeQueueName(ApiServersExchange, node)
+		t.Queues = append(t.Queues, Queue{name, []string{ApiServersExchange}, cfg.QueueArgs(name)})
+	}
+	for _, node := range cfg.DataNodes {
+		name := NodeQueueName(DataServersExchange, node)
+		t.Queues = append(t.Queues, Queue{name, []string{DataServersExchange}, cfg.QueueArgs(name)})
+	}
+	return t
+}
+
+// QueueArgs 具名队列的声明参数, 服务进程与 ossctl 声明同一个队列时参数必须一致, 否则 RabbitMQ 拒绝声明
+//
+// 节点队列中的心跳与定位请求过期后已没有意义, 直接丢弃而不转入死信队列
+func (cfg BrokerConfig) QueueArgs(name string) amqp.Table {
+	args := amqp.Table{amqp.QueueTypeArg: cfg.QueueType}
+	switch {
+	case name == cfg.DeadLetterExchange:
+		args[amqp.QueueMessageTTLArg] = cfg.DeadLetterTTL.Milliseconds()
+	case strings.HasPrefix(name, ApiServersExchange+"."), strings.HasPrefix(name, DataServersExchange+"."):
+		args[amqp.QueueMessageTTLArg] = cfg.MessageTTL.Milliseconds()
+	default:
+		args["x-dead-letter-exchange"] = cfg.DeadLetterExchange
+	}
+	return args
+}
@@ -0,0 +1,114 @@
+package types
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// MessageVersion 当前的消息格式版本, 只在不兼容的修改时增加, 新增字段不改变版本;
+// 版本 0 为引入信封之前的格式: 心跳为 Heartbeat, 定位请求为对象名字符串, 定位回复为 LocateMessage
+const MessageVersion = 1
+
+// 消息类型
+const (
+	HeartbeatMessage = "heartbeat"
+	LocateRequest    = "locate"
+	LocateReply      = "locate-reply"
+)
+
+// Envelope 接口服务与数据服务之间的消息
+type Envelope struct {
+	Version int
+	Type    string
+	Node    string    // 发送者的节点 id
+	Time    time.Time // 发送时间
+	Payload json.RawMessage
+}
+
+// ErrUnsupportedVersion 消息版本比本节点支持的新, 需要先升级本节点
+var ErrUnsupportedVersion = errors.New("unsupported message version")
+
+// peerTTL 超过这段时间没有收到消息的节点不再影响发送的格式版本
+const peerTTL = time.Minute
+
+// peer 一个对端节点能够读取的格式版本
+type peer struct {
+	version  int
+	lastSeen time.Time
+}
+
+var (
+	peers      = make(map[string]peer)
+	peersMutex sync.Mutex
+)
+
+// ObservePeer 记录节点 node 能够读取 version 格式的消息, 如它在心跳中声明支持该版本,
+// 或者收到了它以该版本发送的消息; 以最近一次记录为准, 节点回退到旧版本后随之降低
+func ObservePeer(node string, version int) {
+	peersMutex.Lock()
+	defer peersMutex.Unlock()
+	peers[node] = peer{min(version, MessageVersion), time.Now()}
+}
+
+// SendVersion 广播消息使用的格式版本: 最近 peerTTL 内收到过消息的所有节点都能读取的最高版本;
+// 还不知道任何节点时为版本 0, 所有节点都能读取, 滚动升级期间尚未升级的节点也不受影响
+func SendVersion() int {
+	peersMutex.Lock()
+	defer peersMutex.Unlock()
+	version := -1
+	for node, p := range peers {
+		if time.Since(p.lastSeen) >= peerTTL {
+			delete(peers, node)
+			continue
+		}
+		if version == -1 || p.version < version {
+			version = p.version
+		}
+	}
+	return max(version, 0)
+}
+
+// NodeId 本节点的 id, 由 NODE_ID 配置, 默认为 LISTEN_ADDRESS
+func NodeId() string {
+	if id := os.Getenv("NODE_ID"); id != "" {
+		return id
+	}
+	return os.Getenv("LISTEN_ADDRESS")
+}
+
+// SealMessage 返回以 version 格式发送 payload 的消息体, 版本 0 时即为 payload 本身
+func SealMessage(version int, typ string, payload any) (any, error) {
+	if version == 0 {
+		return payload, nil
+	}
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return Envelope{Version: version, Type: typ, Node: NodeId(), Time: time.Now().UTC(), Payload: b}, nil
+}
+
+// OpenMessage 解析类型为 typ 的消息, 将内容解码到 payload; 兼容版本 0 的消息, 返回的信封 Version 为 0.
+// 版本比 MessageVersion 新时返回 ErrUnsupportedVersion
+func OpenMessage(body []byte, typ string, payload any) (Envelope, error) {
+	var env Envelope
+	var probe struct{ Payload json.RawMessage }
+	if json.Unmarshal(body, &probe) != nil || probe.Payload == nil {
+		env.Type = typ
+		return env, json.Unmarshal(body, payload)
+	}
+	if err := json.Unmarshal(body, &env); err != nil {
+		return env, err
+	}
+	if env.Version <= 0 || env.Version > MessageVersion {
+		return env, fmt.Errorf("%w %d from %s", ErrUnsupportedVersion, env.Version, env.Node)
+	}
+	if env.Type != typ {
+		return env, fmt.Errorf("unexpected message type %q from %s, want %q", env.Type, env.Node, typ)
+	}
+	return env, json.Unmarshal(env.Payload, payload)
+}
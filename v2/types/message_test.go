@@ -0,0 +1,107 @@
+package types
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+// resetPeers 清空已知节点, 测试结束后再次清空
+func resetPeers(t *testing.T) {
+	reset := func() {
+		peersMutex.Lock()
+		peers = make(map[string]peer)
+		peersMutex.Unlock()
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func seal(t *testing.T, version int, typ string, payload any) []byte {
+	body, err := SealMessage(version, typ, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestOpenMessage(t *testing.T) {
+	hb := Heartbeat{Addr: "10.0.0.1:8081", Zone: "a", Version: Version, MessageVersion: MessageVersion}
+	for _, version := range []int{0, MessageVersion} {
+		var object string
+		env, err := OpenMessage(seal(t, version, LocateRequest, "abc"), LocateRequest, &object)
+		if err != nil || env.Version != version || object != "abc" {
+			t.Errorf("locate request v%d = %q, %+v, %v", version, object, env, err)
+		}
+		var reply LocateMessage
+		env, err = OpenMessage(seal(t, version, LocateReply, LocateMessage{"10.0.0.1:8081", 2}), LocateReply, &reply)
+		if err != nil || env.Version != version || reply != (LocateMessage{"10.0.0.1:8081", 2}) {
+			t.Errorf("locate reply v%d = %+v, %+v, %v", version, reply, env, err)
+		}
+		var got Heartbeat
+		env, err = OpenMessage(seal(t, version, HeartbeatMessage, hb), HeartbeatMessage, &got)
+		if err != nil || env.Version != version || got != hb {
+			t.Errorf("heartbeat v%d = %+v, %+v, %v", version, got, env, err)
+		}
+	}
+}
+
+// TestLegacyReader 升级前的节点直接解码消息体, 只能读取版本 0 的消息
+func TestLegacyReader(t *testing.T) {
+	var object string
+	if err := json.Unmarshal(seal(t, 0, LocateRequest, "abc"), &object); err != nil || object != "abc" {
+		t.Errorf("legacy reader on v0 locate request = %q, %v", object, err)
+	}
+	if err := json.Unmarshal(seal(t, MessageVersion, LocateRequest, "abc"), &object); err == nil {
+		t.Error("legacy reader decoded an enveloped locate request")
+	}
+	// 升级前的心跳不带 MessageVersion, 解码后为 0
+	var hb Heartbeat
+	if _, err := OpenMessage([]byte(`{"Addr":"10.0.0.1:8081","Version":"v2"}`), HeartbeatMessage, &hb); err != nil || hb.MessageVersion != 0 {
+		t.Errorf("legacy heartbeat = %+v, %v", hb, err)
+	}
+}
+
+func TestOpenMessageRejects(t *testing.T) {
+	future, _ := json.Marshal(Envelope{Version: MessageVersion + 1, Type: LocateRequest, Payload: json.RawMessage(`"abc"`)})
+	var object string
+	if _, err := OpenMessage(future, LocateRequest, &object); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("future version: %v, want ErrUnsupportedVersion", err)
+	}
+	if _, err := OpenMessage(seal(t, MessageVersion, LocateReply, LocateMessage{"a", 1}), LocateRequest, &object); err == nil {
+		t.Error("locate reply accepted as locate request")
+	}
+}
+
+func TestSendVersion(t *testing.T) {
+	resetPeers(t)
+	if v := SendVersion(); v != 0 {
+		t.Errorf("no known peers: %d, want 0", v)
+	}
+	ObservePeer("a", MessageVersion)
+	ObservePeer("b", MessageVersion+1)
+	if v := SendVersion(); v != MessageVersion {
+		t.Errorf("all peers upgraded: %d, want %d", v, MessageVersion)
+	}
+	ObservePeer("c", 0)
+	if v := SendVersion(); v != 0 {
+		t.Errorf("legacy peer: %d, want 0", v)
+	}
+	// 旧节点升级后以最近一次记录为准
+	ObservePeer("c", MessageVersion)
+	if v := SendVersion(); v != MessageVersion {
+		t.Errorf("legacy peer upgraded: %d, want %d", v, MessageVersion)
+	}
+	// 长时间没有消息的旧节点不再计入
+	peersMutex.Lock()
+	peers["d"] = peer{0, time.Now().Add(-peerTTL)}
+	peersMutex.Unlock()
+	if v := SendVersion(); v != MessageVersion {
+		t.Errorf("expired legacy peer: %d, want %d", v, MessageVersion)
+	}
+}
@@ -0,0 +1,51 @@
+package types
+
+// WholeObject 定位消息中表示完整对象(而非纠删码分片)的 Id
+const WholeObject = -1
+
+// LocateMessage 数据服务对定位请求的回复
+type LocateMessage struct {
+	Addr string
+	Id   int
+}
+
+// Version 软件版本, 随心跳上报
+const Version = "v2"
+
+// Heartbeat 数据服务的心跳消息
+type Heartbeat struct {
+	Addr        string
+	FreeBytes   uint64 // 磁盘剩余空间
+	TotalBytes  uint64 // 磁盘总空间, 为 0 表示未知
+	UsedBytes   int64  // 对象占用的空间
+	ObjectCount int
+	Zone        string // 可用区/机架标签
+	Version     string
+	// MessageVersion 能够读取的最高消息格式版本, 升级前的数据服务没有这个字段, 即只能读取版本 0
+	MessageVersion int `json:",omitempty"`
+}
+
+// ScrubQueue 数据服务上报损坏对象的队列, 由接口服务共同消费
+const ScrubQueue = "scrubReports"
+
+// DamageReport 数据服务巡检发现的损坏文件, 已被隔离, 需要接口服务从副本或校验分片中重建
+type DamageReport struct {
+	Addr string
+	Hash string // url.PathEscape 转义后的对象散列值
+	Id   int    // 分片id, 完整对象为 WholeObject
+}
+
+// ObjectEntry 数据服务上保存的一个对象文件
+type ObjectEntry struct {
+	Hash string // url.PathEscape 转义后的对象散列值
+	Id   int    // 分片id, 完整对象为 WholeObject
+	Size int64  // 文件大小, 压缩保存时为压缩后的大小, 加密保存时为加密前的大小
+	// Compression 文件的压缩算法, 为空表示未压缩
+	Compression string `json:",omitempty"`
+}
+
+// ObjectList 数据服务的对象列表, 按散列值排序, 同一散列值的所有文件在同一页中
+type ObjectList struct {
+	Objects    []ObjectEntry
+	NextMarker string // 不为空时以此为 marker 继续列出
+}
@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// 数据服务支持的压缩算法, 与 HTTP 的 Content-Encoding 取值相同
+const (
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// 对象文件名的后缀: 压缩保存的文件名为 <文件名>~<压缩算法>, 加密保存的文件名后再追加 ~aes,
+// base64 散列值中不会出现 ~
+const (
+	fileNameSeparator = "~"
+	encryptedSuffix   = "aes"
+)
+
+// ValidCompression 判断压缩算法是否受支持, 空字符串表示不压缩
+func ValidCompression(c string) bool {
+	return c == "" || c == CompressionGzip || c == CompressionZstd
+}
+
+// CompressedFileName 压缩保存的对象文件名
+func CompressedFileName(file, compression string) string {
+	if compression == "" {
+		return file
+	}
+	return file + fileNameSeparator + compression
+}
+
+// EncryptedFileName 加密保存的对象文件名, file 可以是压缩保存的文件名
+func EncryptedFileName(file string) string {
+	return file + fileNameSeparator + encryptedSuffix
+}
+
+// SplitFileName 从对象文件名中分离出压缩算法与是否加密, 未压缩的文件压缩算法为空
+func SplitFileName(file string) (name, compression string, encrypted bool) {
+	name, suffix, _ := strings.Cut(file, fileNameSeparator)
+	for _, s := range strings.Split(suffix, fileNameSeparator) {
+		if s == encryptedSuffix {
+			encrypted = true
+		} else if s != "" {
+			compression = s
+		}
+	}
+	return name, compression, encrypted
+}
+
+// NewCompressWriter 返回以 compression 压缩后写入 w 的 io.WriteCloser, Close 时写入剩余的压缩数据, 不关闭 w
+func NewCompressWriter(w io.Writer, compression string) (io.WriteCloser, error) {
+	switch compression {
+	case CompressionGzip:
+		return gzip.NewWriter(w), nil
+	case CompressionZstd:
+		return zstd.NewWriter(w)
+	}
+	return nil, fmt.Errorf("unsupported compression %q", compression)
+}
+
+// NewDecompressReader 返回读取时解压 r 的 io.ReadCloser, Close 时不关闭 r
+func NewDecompressReader(r io.Reader, compression string) (io.ReadCloser, error) {
+	switch compression {
+	case CompressionGzip:
+		return gzip.NewReader(r)
+	case CompressionZstd:
+		d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("unsupported compression %q", compression)
+}
+
+// AcceptsEncoding 判断请求头 Accept-Encoding 是否接受该编码, q=0 表示不接受, 没有单独列出时以 * 为准
+func AcceptsEncoding(h http.Header, encoding string) bool {
+	star := false
+	for _, value := range h.Values("Accept-Encoding") {
+		for _, e := range strings.Split(value, ",") {
+			name, params, _ := strings.Cut(e, ";")
+			name = strings.TrimSpace(name)
+			accepted := !zeroQuality(params)
+			if strings.EqualFold(name, encoding) {
+				return accepted
+			}
+			if name == "*" {
+				star = accepted
+			}
+		}
+	}
+	return star
+}
+
+func zeroQuality(params string) bool {
+	q, ok := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q=")
+	if !ok {
+		return false
+	}
+	f, err := strconv.ParseFloat(q, 64)
+	return err == nil && f == 0
+}
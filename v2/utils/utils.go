@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// GetHashFromHeader 从 `Digest: SHA-256=<base64>` 请求头中取出对象散列值
+func GetHashFromHeader(h http.Header) string {
+	digest := h.Get("Digest")
+	if len(digest) < 9 {
+		return ""
+	}
+	if !strings.EqualFold(digest[:8], "SHA-256=") {
+		return ""
+	}
+	return digest[8:]
+}
+
+// CalculateHash 计算数据流的 SHA-256 散列值, 以 base64 编码返回; 读取失败时返回错误, 而不是不完整数据的散列值
+func CalculateHash(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// ParseRange 解析 `Range: bytes=a-b` 请求头, 返回区间的起始偏移与长度, 只支持单个区间
+func ParseRange(s string, size int64) (offset, length int64, err error) {
+	spec, ok := strings.CutPrefix(s, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, 0, fmt.Errorf("unsupported range %q", s)
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid range %q", s)
+	}
+	if first == "" {
+		// bytes=-n 表示最后 n 个字节
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 || size == 0 {
+			return 0, 0, fmt.Errorf("invalid range %q", s)
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, nil
+	}
+	offset, err = strconv.ParseInt(first, 10, 64)
+	if err != nil || offset < 0 || offset >= size {
+		return 0, 0, fmt.Errorf("range %q not satisfiable for size %d", s, size)
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < offset {
+			return 0, 0, fmt.Errorf("invalid range %q", s)
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return offset, end - offset + 1, nil
+}
+
+// GetOffsetFromHeader 解析断点续传的 `Range: bytes=<offset>-` 请求头, 格式不符时返回 -1
+func GetOffsetFromHeader(h http.Header) int64 {
+	spec, ok := strings.CutPrefix(h.Get("Range"), "bytes=")
+	if !ok {
+		return -1
+	}
+	first, last, ok := strings.Cut(spec, "-")
+	if !ok || last != "" {
+		return -1
+	}
+	offset, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || offset < 0 {
+		return -1
+	}
+	return offset
+}